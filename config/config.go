@@ -2,23 +2,86 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
 )
 
 type ConnectionParams struct {
-	Password          string `json:"password"`
+	Password          string `json:"password,omitempty"`
+	PasswordEnv       string `json:"password_env,omitempty"`
+	PasswordCommand   string `json:"password_command,omitempty"`
 	Host              string `json:"host"`
 	Port              string `json:"port"`
 	User              string `json:"user"`
 	DBName            string `json:"db_name"`
 	SupabaseProjectID string `json:"supabase_project_id"`
+	SSLMode           string `json:"sslmode,omitempty"`
+	SSLRootCert       string `json:"sslrootcert,omitempty"`
+	SSLCert           string `json:"sslcert,omitempty"`
+	SSLKey            string `json:"sslkey,omitempty"`
+
+	Tags      []string `json:"tags,omitempty"`
+	Protected bool     `json:"protected,omitempty"`
+
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+
+	// Compress is the default compression for backups: gzip, zstd or empty for none
+	Compress      string `json:"compress,omitempty"`
+	CompressLevel int    `json:"compress_level,omitempty"`
+	// Format is the default pg_dump format for backups: plain, custom or
+	// directory. Jobs is the number of parallel workers for directory dumps
+	// and archive restores
+	Format string `json:"format,omitempty"`
+	Jobs   int    `json:"jobs,omitempty"`
+	// Recipients are the public keys backups of this connection are always
+	// encrypted to
+	Recipients []string `json:"recipients,omitempty"`
+
+	// HostAddr is the numeric address to connect to while Host is still used
+	// for TLS verification, as with libpq's hostaddr
+	HostAddr string     `json:"hostaddr,omitempty"`
+	SSH      *SSHTunnel `json:"ssh,omitempty"`
+
+	// Supavisor endpoints, used alongside or instead of the direct Host/Port
+	SessionPooler     *Endpoint `json:"session_pooler,omitempty"`
+	TransactionPooler *Endpoint `json:"transaction_pooler,omitempty"`
+}
+
+type Endpoint struct {
+	Host string `json:"host"`
+	Port string `json:"port,omitempty"`
+	User string `json:"user,omitempty"`
+}
+
+// SSHTunnel describes a bastion host the database is reached through.
+type SSHTunnel struct {
+	Host       string `json:"host"`
+	Port       string `json:"port,omitempty"`
+	User       string `json:"user,omitempty"`
+	KeyPath    string `json:"key_path,omitempty"`
+	KnownHosts string `json:"known_hosts,omitempty"`
 }
 
 type BinaryPaths struct {
 	PSQL      string `json:"psql"`
 	PGDumpAll string `json:"pg_dumpall"`
 	PGDump    string `json:"pg_dump"`
+	PGRestore string `json:"pg_restore,omitempty"`
 	Supabase  string `json:"supabase"`
+	SSH       string `json:"ssh,omitempty"`
+	Zstd      string `json:"zstd,omitempty"`
+
+	// Versions lists additional PostgreSQL installations keyed by major
+	// version, so the tools can be matched to each server
+	Versions map[string]PGTools `json:"versions,omitempty"`
+}
+
+type PGTools struct {
+	PSQL      string `json:"psql,omitempty"`
+	PGDumpAll string `json:"pg_dumpall,omitempty"`
+	PGDump    string `json:"pg_dump,omitempty"`
+	PGRestore string `json:"pg_restore,omitempty"`
 }
 
 type Editor struct {
@@ -26,38 +89,87 @@ type Editor struct {
 }
 
 type Config struct {
+	Version     int                         `json:"version"`
 	Connections map[string]ConnectionParams `json:"connections"`
 	Binaries    BinaryPaths                 `json:"binaries"`
 	Editor      Editor                      `json:"editor"`
+	Vault       VaultSettings               `json:"vault"`
+
+	ExcludedSchemas []string `json:"excluded_schemas,omitempty"`
+	BackupRoot      string   `json:"backup_root,omitempty"`
+	// Retention applies to connections that don't set their own
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+	// IdentityFiles hold the private keys tried when reading encrypted backups
+	IdentityFiles []string `json:"identity_files,omitempty"`
+
+	path  string
+	vault *Vault
+
+	// set when a project-local config has been merged in, see ApplyLocal
+	local     *Config
+	localPath string
+	base      *Config
+	loaded    *Config
+}
+
+// DefaultExcludedSchemas are the Supabase-managed schemas left out of dumps
+// unless the config lists its own.
+var DefaultExcludedSchemas = []string{
+	"auth", "cron", "extensions", "graphql", "graphql_public", "net", "pgbouncer", "pgsodium", "pgsodium_masks",
+	"realtime", "storage", "supabase_functions", "supabase_migrations", "vault", "_realtime",
 }
 
 func Load(filePath string) (*Config, error) {
+	vault, err := loadVault(vaultPath(filePath))
+	if err != nil {
+		return nil, err
+	}
+
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return &Config{
+				Version:     CurrentVersion,
 				Connections: make(map[string]ConnectionParams),
 				Binaries:    BinaryPaths{},
+				path:        filePath,
+				vault:       vault,
 			}, nil
 		}
 		return nil, err
 	}
 
+	data, err = migrate(filePath, data)
+	if err != nil {
+		return nil, err
+	}
+
 	var cfg Config
 	err = json.Unmarshal(data, &cfg)
 	if err != nil {
 		return nil, err
 	}
+	if cfg.Connections == nil {
+		cfg.Connections = make(map[string]ConnectionParams)
+	}
+	cfg.path = filePath
+	cfg.vault = vault
 
 	return &cfg, nil
 }
 
 func (c *Config) Save(filePath string) error {
-	data, err := json.MarshalIndent(c, "", "  ")
+	if c.vault != nil {
+		if err := c.sealPasswords(); err != nil {
+			return fmt.Errorf("failed to update vault: %w", err)
+		}
+	}
+
+	data, err := json.MarshalIndent(c.userView(), "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filePath, data, 0644)
+	return WriteFileAtomic(filePath, data)
 }
 
 func (c *Config) AddConnection(id string, params ConnectionParams) {
@@ -88,3 +200,46 @@ func (c *Config) SetBinaryPaths(paths BinaryPaths) {
 func (c *Config) GetBinaryPaths() BinaryPaths {
 	return c.Binaries
 }
+
+// RetentionPolicy decides which backup sets `db backup prune` keeps. A set is
+// kept when any of the rules keeps it.
+type RetentionPolicy struct {
+	KeepLast    int `json:"keep_last,omitempty"`
+	KeepDaily   int `json:"keep_daily,omitempty"`
+	KeepWeekly  int `json:"keep_weekly,omitempty"`
+	KeepMonthly int `json:"keep_monthly,omitempty"`
+}
+
+func (p RetentionPolicy) IsZero() bool {
+	return p == RetentionPolicy{}
+}
+
+// GetRetention returns the retention policy for a connection, if it or the
+// config sets one.
+func (c *Config) GetRetention(params ConnectionParams) (RetentionPolicy, bool) {
+	if params.Retention != nil && !params.Retention.IsZero() {
+		return *params.Retention, true
+	}
+	if c.Retention != nil && !c.Retention.IsZero() {
+		return *c.Retention, true
+	}
+	return RetentionPolicy{}, false
+}
+
+// DefaultBackupRoot is the directory next to the config file that holds backup
+// sets when the config doesn't name one, so they are found from anywhere.
+const DefaultBackupRoot = "backups"
+
+func (c *Config) GetBackupRoot() string {
+	if c.BackupRoot == "" {
+		return filepath.Join(filepath.Dir(c.path), DefaultBackupRoot)
+	}
+	return c.BackupRoot
+}
+
+func (c *Config) GetExcludedSchemas() []string {
+	if len(c.ExcludedSchemas) == 0 {
+		return DefaultExcludedSchemas
+	}
+	return c.ExcludedSchemas
+}
@@ -0,0 +1,325 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// localFileNames are the project-local config files looked for in the working
+// directory and each of its parents, in order of preference.
+var localFileNames = []string{
+	".proman.json",
+	filepath.Join(".proman", "config.json"),
+}
+
+// FindLocal returns the closest project-local config file at or above dir.
+func FindLocal(dir string) (string, bool) {
+	for {
+		for _, name := range localFileNames {
+			candidate := filepath.Join(dir, name)
+			if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+				return candidate, true
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// localConfig is what a project-local config may set. The file usually comes
+// with a repository, so it can't hold binaries, password commands, tunnels or
+// anything else that would have proman run a program.
+type localConfig struct {
+	Version         int                        `json:"version"`
+	Connections     map[string]localConnection `json:"connections"`
+	ExcludedSchemas []string                   `json:"excluded_schemas,omitempty"`
+}
+
+type localConnection struct {
+	Host              string   `json:"host"`
+	Port              string   `json:"port"`
+	User              string   `json:"user"`
+	DBName            string   `json:"db_name"`
+	SupabaseProjectID string   `json:"supabase_project_id"`
+	Tags              []string `json:"tags,omitempty"`
+}
+
+// ApplyLocal merges a project-local config over the user config. Only the values
+// the local file sets are taken from it, so a connection can be defined locally
+// while its password stays in the user config or vault. Save keeps writing
+// just the user config and never copies local values into it.
+func (c *Config) ApplyLocal(filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	var allowed localConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&allowed); err != nil {
+		return fmt.Errorf(
+			"failed to parse %s: %w (a project-local config can only set excluded_schemas and the host, port, user, db_name, supabase_project_id and tags of connections)",
+			filePath, err,
+		)
+	}
+	if allowed.Version > CurrentVersion {
+		return fmt.Errorf(
+			"config file %s has version %d, but this build of proman only understands up to version %d. Please upgrade proman",
+			filePath, allowed.Version, CurrentVersion,
+		)
+	}
+
+	local := Config{Version: allowed.Version, ExcludedSchemas: allowed.ExcludedSchemas}
+	if len(allowed.Connections) > 0 {
+		local.Connections = make(map[string]ConnectionParams, len(allowed.Connections))
+		for id, conn := range allowed.Connections {
+			if existing, found := c.Connections[id]; found {
+				if field := redirectedField(existing, conn); field != "" {
+					return fmt.Errorf(
+						"%s changes the %s of connection '%s', which is defined in %s. A project-local config can't point an existing connection at another server, since its password would be sent there. Use a new connection ID instead",
+						filePath, field, id, c.path,
+					)
+				}
+			}
+			local.Connections[id] = ConnectionParams{
+				Host:              conn.Host,
+				Port:              conn.Port,
+				User:              conn.User,
+				DBName:            conn.DBName,
+				SupabaseProjectID: conn.SupabaseProjectID,
+				Tags:              conn.Tags,
+			}
+		}
+	}
+
+	base, err := c.clone()
+	if err != nil {
+		return err
+	}
+	overlay(reflect.ValueOf(c).Elem(), reflect.ValueOf(local))
+	loaded, err := c.clone()
+	if err != nil {
+		return err
+	}
+
+	c.base = base
+	c.loaded = loaded
+	c.local = &local
+	c.localPath = filePath
+	return nil
+}
+
+// redirectedField names the first field of a local connection entry that would
+// send an existing connection, and with it the password, somewhere else.
+func redirectedField(existing ConnectionParams, local localConnection) string {
+	switch {
+	case local.Host != "" && local.Host != existing.Host:
+		return "host"
+	case local.Port != "" && local.Port != existing.Port:
+		return "port"
+	case local.User != "" && local.User != existing.User:
+		return "user"
+	case local.SupabaseProjectID != "" && local.SupabaseProjectID != existing.SupabaseProjectID:
+		return "supabase_project_id"
+	}
+	return ""
+}
+
+func (c *Config) clone() (*Config, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var copied Config
+	if err := json.Unmarshal(data, &copied); err != nil {
+		return nil, err
+	}
+	return &copied, nil
+}
+
+// overlay copies every non-zero value of src onto dst, recursing into structs
+// and maps so partially specified entries only replace the fields they set.
+func overlay(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Struct:
+		for i := 0; i < src.NumField(); i++ {
+			if !src.Type().Field(i).IsExported() {
+				continue
+			}
+			overlay(dst.Field(i), src.Field(i))
+		}
+	case reflect.Map:
+		if src.Len() == 0 {
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMap(dst.Type()))
+		}
+		for _, key := range src.MapKeys() {
+			merged := reflect.New(dst.Type().Elem()).Elem()
+			if existing := dst.MapIndex(key); existing.IsValid() {
+				merged.Set(existing)
+			}
+			overlay(merged, src.MapIndex(key))
+			dst.SetMapIndex(key, merged)
+		}
+	default:
+		if !src.IsZero() {
+			dst.Set(src)
+		}
+	}
+}
+
+// userView undoes ApplyLocal for saving: values still equal to what was loaded
+// are replaced by the user config's own values, while anything a command
+// changed since loading is kept. Entries that only exist in the local file,
+// such as a connection defined there, stay out of the user config.
+func (c *Config) userView() *Config {
+	if c.local == nil {
+		return c
+	}
+	view := *c
+	unoverlay(reflect.ValueOf(&view).Elem(), reflect.ValueOf(*c.loaded), reflect.ValueOf(*c.base))
+	return &view
+}
+
+func unoverlay(current, loaded, base reflect.Value) {
+	switch current.Kind() {
+	case reflect.Struct:
+		for i := 0; i < current.NumField(); i++ {
+			if !current.Type().Field(i).IsExported() {
+				continue
+			}
+			unoverlay(current.Field(i), loaded.Field(i), base.Field(i))
+		}
+	case reflect.Map:
+		if current.IsNil() {
+			return
+		}
+		result := reflect.MakeMap(current.Type())
+		for _, key := range current.MapKeys() {
+			value := current.MapIndex(key)
+			loadedValue := loaded.MapIndex(key)
+			baseValue := base.MapIndex(key)
+
+			if !loadedValue.IsValid() {
+				result.SetMapIndex(key, value)
+				continue
+			}
+			if !baseValue.IsValid() {
+				continue
+			}
+			if reflect.DeepEqual(value.Interface(), loadedValue.Interface()) {
+				result.SetMapIndex(key, baseValue)
+				continue
+			}
+			merged := reflect.New(current.Type().Elem()).Elem()
+			merged.Set(value)
+			unoverlay(merged, loadedValue, baseValue)
+			result.SetMapIndex(key, merged)
+		}
+		current.Set(result)
+	default:
+		if reflect.DeepEqual(current.Interface(), loaded.Interface()) {
+			current.Set(base)
+		}
+	}
+}
+
+type Source struct {
+	Key   string
+	Value string
+	File  string
+}
+
+// Sources lists every value set in the effective config together with the file
+// it came from.
+func (c *Config) Sources() []Source {
+	values := map[string]string{}
+	flatten(reflect.ValueOf(*c), "", values)
+
+	fromLocal := map[string]string{}
+	if c.local != nil {
+		flatten(reflect.ValueOf(*c.local), "", fromLocal)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	sources := make([]Source, 0, len(keys))
+	for _, key := range keys {
+		source := Source{Key: key, Value: values[key], File: c.path}
+		if _, found := fromLocal[key]; found {
+			source.File = c.localPath
+		}
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// Files returns the config files that make up the effective config, user
+// config first.
+func (c *Config) Files() []string {
+	files := []string{c.path}
+	if c.localPath != "" {
+		files = append(files, c.localPath)
+	}
+	return files
+}
+
+// flatten records each non-zero leaf of v under its dotted JSON key.
+func flatten(v reflect.Value, prefix string, out map[string]string) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			flatten(v.Field(i), joinKey(prefix, name), out)
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			flatten(v.MapIndex(key), joinKey(prefix, fmt.Sprint(key.Interface())), out)
+		}
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return
+		}
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		out[prefix] = strings.Join(items, ",")
+	default:
+		if !v.IsZero() {
+			out[prefix] = fmt.Sprint(v.Interface())
+		}
+	}
+}
+
+func joinKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const userConfig = `{
+  "version": 1,
+  "connections": {
+    "prod": {"password": "secret", "host": "db.example.com", "port": "5432", "user": "postgres", "db_name": "postgres", "supabase_project_id": "abcdefghijklmnopqrst"}
+  }
+}`
+
+// loadWithLocal loads userConfig and merges the given project-local file over it.
+func loadWithLocal(t *testing.T, local string) (*Config, error) {
+	t.Helper()
+	dir := t.TempDir()
+	userPath := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(userPath, []byte(userConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	localPath := filepath.Join(dir, ".proman.json")
+	if err := os.WriteFile(localPath, []byte(local), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(userPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg, cfg.ApplyLocal(localPath)
+}
+
+func TestApplyLocalRefusesRedirects(t *testing.T) {
+	tests := []struct {
+		name  string
+		local string
+		want  string
+	}{
+		{"host", `{"connections": {"prod": {"host": "evil.example.com"}}}`, "changes the host"},
+		{"port", `{"connections": {"prod": {"port": "6543"}}}`, "changes the port"},
+		{"user", `{"connections": {"prod": {"user": "attacker"}}}`, "changes the user"},
+		{"supabase project", `{"connections": {"prod": {"supabase_project_id": "zzzzzzzzzzzzzzzzzzzz"}}}`, "changes the supabase_project_id"},
+		{"binaries", `{"binaries": {"psql": "/tmp/psql"}}`, "unknown field"},
+		{"password command", `{"connections": {"dev": {"password_command": "curl evil"}}}`, "unknown field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadWithLocal(t, tt.local)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyLocalMerges(t *testing.T) {
+	cfg, err := loadWithLocal(t, `{
+  "connections": {
+    "prod": {"host": "db.example.com", "db_name": "app", "tags": ["team"]},
+    "dev": {"host": "localhost", "port": "54322", "user": "postgres", "db_name": "postgres"}
+  },
+  "excluded_schemas": ["audit"]
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prod := cfg.Connections["prod"]
+	if prod.Host != "db.example.com" || prod.User != "postgres" || prod.Password != "secret" {
+		t.Errorf("prod connection details changed: %+v", prod)
+	}
+	if prod.DBName != "app" || len(prod.Tags) != 1 || prod.Tags[0] != "team" {
+		t.Errorf("prod didn't pick up the local db_name and tags: %+v", prod)
+	}
+	if _, found := cfg.Connections["dev"]; !found {
+		t.Error("the locally defined dev connection is missing")
+	}
+
+	if err := cfg.Save(cfg.path); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := Load(cfg.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := saved.Connections["dev"]; found {
+		t.Error("the locally defined dev connection was written to the user config")
+	}
+	if saved.Connections["prod"].DBName != "postgres" || len(saved.ExcludedSchemas) != 0 {
+		t.Errorf("local values were written to the user config: %+v", saved)
+	}
+}
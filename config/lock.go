@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Lock takes an exclusive advisory lock on the config file and reloads it from
+// disk, so a following Save doesn't clobber changes other proman processes made
+// since Load. Call the returned function once the config has been saved.
+func (c *Config) Lock() (func(), error) {
+	f, err := os.OpenFile(c.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config lock: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", c.path, err)
+	}
+	unlock := func() {
+		unlockFile(f)
+		f.Close()
+	}
+
+	fresh, err := Load(c.path)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	if c.localPath != "" {
+		if err := fresh.ApplyLocal(c.localPath); err != nil {
+			unlock()
+			return nil, err
+		}
+	}
+	// keep an already unlocked vault so the passphrase isn't asked for twice
+	if c.vault != nil && fresh.vault != nil && c.vault.unlocked() && c.vault.KDF == fresh.vault.KDF {
+		fresh.vault.aead = c.vault.aead
+	}
+
+	*c = *fresh
+	return unlock, nil
+}
+
+// WriteFileAtomic replaces filePath with data through a synced temporary file in
+// the same directory, so readers and crashes never see a partial write. The
+// result is only readable by the owner.
+func WriteFileAtomic(filePath string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
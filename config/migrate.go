@@ -0,0 +1,107 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// CurrentVersion is the config.json layout written by this build of proman.
+const CurrentVersion = 1
+
+// migrations[n] upgrades a raw config from version n to version n+1.
+var migrations = []func(raw map[string]any) error{
+	migrateV0ToV1,
+}
+
+// migrateV0ToV1 fills in the fields that were added before the config was
+// versioned, so older files don't silently load with empty values.
+func migrateV0ToV1(raw map[string]any) error {
+	connections, _ := raw["connections"].(map[string]any)
+	if connections == nil {
+		connections = make(map[string]any)
+	}
+	for id, value := range connections {
+		params, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("connection '%s' is not an object", id)
+		}
+		setDefault(params, "port", "5432")
+		setDefault(params, "db_name", "postgres")
+		setDefault(params, "supabase_project_id", "")
+	}
+	raw["connections"] = connections
+
+	binaries, _ := raw["binaries"].(map[string]any)
+	if binaries == nil {
+		binaries = make(map[string]any)
+	}
+	for _, name := range []string{"psql", "pg_dumpall", "pg_dump", "supabase"} {
+		setDefault(binaries, name, "")
+	}
+	raw["binaries"] = binaries
+
+	editor, _ := raw["editor"].(map[string]any)
+	if editor == nil {
+		editor = make(map[string]any)
+	}
+	setDefault(editor, "default", "git")
+	raw["editor"] = editor
+
+	return nil
+}
+
+func setDefault(m map[string]any, key string, value any) {
+	if current, ok := m[key]; !ok || current == "" {
+		m[key] = value
+	}
+}
+
+// migrate upgrades data to CurrentVersion. When anything changed, the original
+// file is kept as <filePath>.bak and the upgraded layout is written back.
+func migrate(filePath string, data []byte) ([]byte, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	version := 0
+	if v, ok := raw["version"]; ok {
+		number, ok := v.(float64)
+		if !ok || number != float64(int(number)) || number < 0 {
+			return nil, fmt.Errorf("invalid config version %v", v)
+		}
+		version = int(number)
+	}
+
+	if version > CurrentVersion {
+		return nil, fmt.Errorf(
+			"config file %s has version %d, but this build of proman only understands up to version %d. Please upgrade proman",
+			filePath, version, CurrentVersion,
+		)
+	}
+	if version == CurrentVersion {
+		return data, nil
+	}
+
+	for ; version < CurrentVersion; version++ {
+		if err := migrations[version](raw); err != nil {
+			return nil, fmt.Errorf("failed to migrate config from version %d to %d: %w", version, version+1, err)
+		}
+	}
+	raw["version"] = CurrentVersion
+
+	migrated, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	// written like the config so an older .bak is replaced along with its permissions
+	if err := WriteFileAtomic(filePath+".bak", data); err != nil {
+		return nil, fmt.Errorf("failed to back up config before migrating: %w", err)
+	}
+	if err := WriteFileAtomic(filePath, migrated); err != nil {
+		return nil, fmt.Errorf("failed to write migrated config: %w", err)
+	}
+
+	return migrated, nil
+}
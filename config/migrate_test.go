@@ -0,0 +1,181 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+		// want holds values expected in the migrated config, keyed by a
+		// dotted path
+		want     map[string]any
+		migrated bool
+	}{
+		{
+			name: "v0 file",
+			data: `{"connections": {"prod": {"host": "db.example.com", "user": "postgres", "password": "secret"}}, "binaries": {"psql": "/usr/bin/psql"}}`,
+			want: map[string]any{
+				"version":                              float64(CurrentVersion),
+				"connections.prod.host":                "db.example.com",
+				"connections.prod.password":            "secret",
+				"connections.prod.port":                "5432",
+				"connections.prod.db_name":             "postgres",
+				"connections.prod.supabase_project_id": "",
+				"binaries.psql":                        "/usr/bin/psql",
+				"binaries.pg_dump":                     "",
+				"editor.default":                       "git",
+			},
+			migrated: true,
+		},
+		{
+			name: "v0 file keeps the values it sets",
+			data: `{"connections": {"local": {"port": "54322", "db_name": "app"}}, "editor": {"default": "code"}}`,
+			want: map[string]any{
+				"version":                   float64(CurrentVersion),
+				"connections.local.port":    "54322",
+				"connections.local.db_name": "app",
+				"editor.default":            "code",
+			},
+			migrated: true,
+		},
+		{
+			name: "current file is left unchanged",
+			data: `{"version": 1, "connections": {"prod": {"host": "db.example.com"}}}`,
+			want: map[string]any{
+				"version":               float64(1),
+				"connections.prod.host": "db.example.com",
+			},
+		},
+		{
+			name:    "future version",
+			data:    `{"version": 2, "connections": {}}`,
+			wantErr: "only understands up to version 1",
+		},
+		{
+			name:    "invalid version",
+			data:    `{"version": "one"}`,
+			wantErr: "invalid config version",
+		},
+		{
+			name:    "connection that isn't an object",
+			data:    `{"connections": {"prod": "postgres://db.example.com"}}`,
+			wantErr: "connection 'prod' is not an object",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := migrate(path, []byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			onDisk, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			backup, backupErr := os.ReadFile(path + ".bak")
+
+			if !tt.migrated {
+				if string(onDisk) != tt.data {
+					t.Errorf("config was rewritten to %s", onDisk)
+				}
+				if !os.IsNotExist(backupErr) {
+					t.Errorf("got a .bak for a config that wasn't migrated: %v", backupErr)
+				}
+			} else {
+				if string(onDisk) != string(got) {
+					t.Errorf("the migrated config wasn't written back: %s", onDisk)
+				}
+				if backupErr != nil {
+					t.Fatalf("no .bak: %v", backupErr)
+				}
+				if string(backup) != tt.data {
+					t.Errorf("got .bak %s, want the original %s", backup, tt.data)
+				}
+				if runtime.GOOS != "windows" {
+					info, err := os.Stat(path + ".bak")
+					if err != nil {
+						t.Fatal(err)
+					}
+					if perm := info.Mode().Perm(); perm != 0600 {
+						t.Errorf("got .bak permissions %v, want 0600", perm)
+					}
+				}
+			}
+
+			if tt.wantErr != "" {
+				return
+			}
+			var raw map[string]any
+			if err := json.Unmarshal(got, &raw); err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range tt.want {
+				if value := lookup(raw, key); value != want {
+					t.Errorf("%s = %#v, want %#v", key, value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateReplacesAnOlderBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"connections": {}}`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".bak", []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrate(path, []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := os.ReadFile(path + ".bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(backup) != data {
+		t.Errorf("got .bak %s, want the original %s", backup, data)
+	}
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path + ".bak")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("got .bak permissions %v, want 0600", perm)
+		}
+	}
+}
+
+// lookup follows a dotted path through decoded JSON objects.
+func lookup(raw map[string]any, path string) any {
+	var value any = raw
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
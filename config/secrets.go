@@ -0,0 +1,104 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var (
+	resolvedMu sync.Mutex
+	// resolved caches passwords looked up from the environment or a command,
+	// so each source is consulted at most once per process
+	resolved = map[string]string{}
+)
+
+// ResolvePassword fills in a connection's password when it isn't stored in the
+// config itself. It comes from the environment variable named by password_env,
+// the first line printed by password_command, or the vault. Nothing is looked
+// up until a command actually needs the credential.
+func (c *Config) ResolvePassword(id string, params ConnectionParams) (ConnectionParams, error) {
+	if params.Password != "" {
+		return params, nil
+	}
+
+	resolvedMu.Lock()
+	defer resolvedMu.Unlock()
+
+	if password, found := resolved[id]; found {
+		params.Password = password
+		return params, nil
+	}
+
+	var password string
+	var err error
+	switch {
+	case params.PasswordEnv != "":
+		password = os.Getenv(params.PasswordEnv)
+		if password == "" {
+			err = fmt.Errorf("password_env %s for '%s' is not set", params.PasswordEnv, id)
+		}
+	case params.PasswordCommand != "":
+		password, err = runPasswordCommand(params.PasswordCommand)
+		if err != nil {
+			err = fmt.Errorf("password_command for '%s' failed: %w", id, err)
+		}
+	case c.vault != nil && c.vault.Secrets[id] != "":
+		password, err = c.vaultPassword(id)
+	default:
+		return params, nil
+	}
+	if err != nil {
+		return params, err
+	}
+
+	resolved[id] = password
+	params.Password = password
+	return params, nil
+}
+
+func runPasswordCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	// password managers may need the terminal to ask for their own passphrase
+	cmd.Stdin = os.Stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	password, _, _ := strings.Cut(string(out), "\n")
+	password = strings.TrimRight(password, "\r")
+	if password == "" {
+		return "", fmt.Errorf("the command printed no password")
+	}
+	return password, nil
+}
+
+// PasswordSource names where ResolvePassword would find the connection's
+// password: config, env, command, vault, or none.
+func (c *Config) PasswordSource(id string, params ConnectionParams) string {
+	switch {
+	case params.Password != "":
+		return "config"
+	case params.PasswordEnv != "":
+		return "env"
+	case params.PasswordCommand != "":
+		return "command"
+	case c.vault != nil && c.vault.Secrets[id] != "":
+		return "vault"
+	default:
+		return "none"
+	}
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const stateFileName = "state.json"
+
+// state records what proman did on earlier runs. It lives apart from the
+// config so routine commands like backups never rewrite the config file.
+type state struct {
+	LastBackup map[string]time.Time `json:"last_backup,omitempty"`
+}
+
+func (c *Config) statePath() string {
+	return filepath.Join(filepath.Dir(c.path), stateFileName)
+}
+
+func readState(path string) (state, error) {
+	var s state
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// LastBackup returns when a backup of the connection last completed.
+func (c *Config) LastBackup(id string) (time.Time, bool) {
+	s, err := readState(c.statePath())
+	if err != nil {
+		return time.Time{}, false
+	}
+	at, found := s.LastBackup[id]
+	return at, found
+}
+
+// RecordBackup notes a completed backup of the connection.
+func (c *Config) RecordBackup(id string, at time.Time) error {
+	path := c.statePath()
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+
+	s, err := readState(path)
+	if err != nil {
+		return err
+	}
+	if s.LastBackup == nil {
+		s.LastBackup = make(map[string]time.Time)
+	}
+	s.LastBackup[id] = at.UTC()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, data)
+}
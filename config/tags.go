@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// HasTag reports whether a connection carries tag exactly, e.g. "env=prod" or
+// a plain group name such as "billing".
+func (p ConnectionParams) HasTag(tag string) bool {
+	for _, t := range p.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// InGroup reports whether a connection belongs to the @name group: either a
+// plain tag equal to name or any key=value tag whose value is name.
+func (p ConnectionParams) InGroup(name string) bool {
+	for _, t := range p.Tags {
+		if t == name {
+			return true
+		}
+		if _, value, found := strings.Cut(t, "="); found && value == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveTargets expands project IDs, @group references and exact tag filters
+// into a sorted, de-duplicated list of connection IDs. Every selector has to
+// match at least one connection.
+func (c *Config) ResolveTargets(selectors []string, tags []string) ([]string, error) {
+	selected := map[string]bool{}
+
+	for _, selector := range selectors {
+		if group, isGroup := strings.CutPrefix(selector, "@"); isGroup {
+			matched := false
+			for id, params := range c.Connections {
+				if params.InGroup(group) {
+					selected[id] = true
+					matched = true
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("no projects found in group '%s'", selector)
+			}
+			continue
+		}
+
+		if _, found := c.GetConnection(selector); !found {
+			return nil, fmt.Errorf("project with ID '%s' not found", selector)
+		}
+		selected[selector] = true
+	}
+
+	for _, tag := range tags {
+		matched := false
+		for id, params := range c.Connections {
+			if params.HasTag(tag) {
+				selected[id] = true
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no projects found with tag '%s'", tag)
+		}
+	}
+
+	ids := make([]string, 0, len(selected))
+	for id := range selected {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
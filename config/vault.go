@@ -0,0 +1,329 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const (
+	vaultFileName   = "vault.json"
+	vaultCheckValue = "proman-vault"
+	vaultIterations = 600000
+
+	// VaultKeyFileEnv points at a file whose contents are used as the vault key.
+	VaultKeyFileEnv = "PROMAN_VAULT_KEY_FILE"
+	// VaultPassphraseEnv holds the master passphrase for non-interactive use.
+	VaultPassphraseEnv = "PROMAN_VAULT_PASSPHRASE"
+)
+
+type VaultSettings struct {
+	KeyFile string `json:"key_file,omitempty"`
+}
+
+type vaultKDF struct {
+	Name       string `json:"name"`
+	Salt       string `json:"salt"`
+	Iterations int    `json:"iterations"`
+}
+
+// Vault stores connection passwords sealed with AES-256-GCM under a key derived
+// from a master passphrase or key file. Secrets are keyed by connection ID.
+type Vault struct {
+	KDF     vaultKDF          `json:"kdf"`
+	Check   string            `json:"check"`
+	Secrets map[string]string `json:"secrets"`
+
+	path string
+	aead cipher.AEAD
+}
+
+func vaultPath(configFile string) string {
+	return filepath.Join(filepath.Dir(configFile), vaultFileName)
+}
+
+func loadVault(path string) (*Vault, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var v Vault
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("failed to parse vault %s: %w", path, err)
+	}
+	if v.Secrets == nil {
+		v.Secrets = make(map[string]string)
+	}
+	v.path = path
+	return &v, nil
+}
+
+func newVault(path string, material []byte) (*Vault, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	v := &Vault{
+		KDF: vaultKDF{
+			Name:       "pbkdf2-sha256",
+			Salt:       base64.StdEncoding.EncodeToString(salt),
+			Iterations: vaultIterations,
+		},
+		Secrets: make(map[string]string),
+		path:    path,
+	}
+	if err := v.deriveKey(material); err != nil {
+		return nil, err
+	}
+
+	check, err := v.seal("", vaultCheckValue)
+	if err != nil {
+		return nil, err
+	}
+	v.Check = check
+	return v, nil
+}
+
+func (v *Vault) deriveKey(material []byte) error {
+	if v.KDF.Name != "pbkdf2-sha256" {
+		return fmt.Errorf("unsupported vault key derivation '%s'", v.KDF.Name)
+	}
+	salt, err := base64.StdEncoding.DecodeString(v.KDF.Salt)
+	if err != nil {
+		return fmt.Errorf("invalid vault salt: %w", err)
+	}
+
+	key, err := pbkdf2.Key(sha256.New, string(material), salt, v.KDF.Iterations, 32)
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	v.aead, err = cipher.NewGCM(block)
+	return err
+}
+
+func (v *Vault) unlocked() bool {
+	return v.aead != nil
+}
+
+func (v *Vault) unlock(settings VaultSettings) error {
+	if v.unlocked() {
+		return nil
+	}
+
+	material, err := vaultKeyMaterial(settings, false)
+	if err != nil {
+		return err
+	}
+	if err := v.deriveKey(material); err != nil {
+		return err
+	}
+	if check, err := v.open("", v.Check); err != nil || check != vaultCheckValue {
+		v.aead = nil
+		return fmt.Errorf("failed to unlock vault: wrong passphrase or key file")
+	}
+	return nil
+}
+
+// seal encrypts plaintext, binding it to id so a secret can't be swapped onto
+// another connection.
+func (v *Vault) seal(id, plaintext string) (string, error) {
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := v.aead.Seal(nonce, nonce, []byte(plaintext), []byte(id))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (v *Vault) open(id, sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	nonceSize := v.aead.NonceSize()
+	if len(data) < nonceSize {
+		return "", errors.New("sealed value is too short")
+	}
+	plaintext, err := v.aead.Open(nil, data[:nonceSize], data[nonceSize:], []byte(id))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func (v *Vault) save() error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(v.path, data)
+}
+
+// vaultKeyMaterial returns the secret the vault key is derived from. A key file
+// wins over a passphrase; the passphrase is read from the environment or, as a
+// last resort, prompted for on the terminal.
+func vaultKeyMaterial(settings VaultSettings, confirm bool) ([]byte, error) {
+	keyFile := os.Getenv(VaultKeyFileEnv)
+	if keyFile == "" {
+		keyFile = settings.KeyFile
+	}
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read vault key file: %w", err)
+		}
+		material := []byte(strings.TrimSpace(string(data)))
+		if len(material) == 0 {
+			return nil, fmt.Errorf("vault key file %s is empty", keyFile)
+		}
+		return material, nil
+	}
+
+	if passphrase := os.Getenv(VaultPassphraseEnv); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	passphrase, err := readPassphrase("Vault passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("vault passphrase cannot be empty")
+	}
+	if confirm {
+		again, err := readPassphrase("Confirm vault passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if string(again) != string(passphrase) {
+			return nil, errors.New("passphrases do not match")
+		}
+	}
+	return passphrase, nil
+}
+
+func readPassphrase(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		passphrase, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return passphrase, err
+	}
+
+	// read a byte at a time so the input after the passphrase is left for
+	// the caller's own reader
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			if len(line) == 0 {
+				return nil, fmt.Errorf("failed to read passphrase: %w", err)
+			}
+			break
+		}
+	}
+	return bytes.TrimRight(line, "\r"), nil
+}
+
+func (c *Config) HasVault() bool {
+	return c.vault != nil
+}
+
+// InitVault creates an empty vault next to the config file. Passwords are moved
+// into it on the next Save.
+func (c *Config) InitVault() error {
+	if c.vault != nil {
+		return fmt.Errorf("a vault already exists at %s", c.vault.path)
+	}
+	material, err := vaultKeyMaterial(c.Vault, true)
+	if err != nil {
+		return err
+	}
+	v, err := newVault(vaultPath(c.path), material)
+	if err != nil {
+		return err
+	}
+	c.vault = v
+	return nil
+}
+
+// PlaintextPasswords lists the connections whose password is still stored in
+// config.json.
+func (c *Config) PlaintextPasswords() []string {
+	ids := []string{}
+	for id, params := range c.Connections {
+		if params.Password != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (c *Config) vaultPassword(id string) (string, error) {
+	if err := c.vault.unlock(c.Vault); err != nil {
+		return "", err
+	}
+	password, err := c.vault.open(id, c.vault.Secrets[id])
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt password for '%s': %w", id, err)
+	}
+	return password, nil
+}
+
+// sealPasswords moves plaintext passwords into the vault and drops secrets of
+// connections that no longer exist or now get their password elsewhere.
+func (c *Config) sealPasswords() error {
+	for id := range c.vault.Secrets {
+		params, found := c.Connections[id]
+		if !found || params.PasswordEnv != "" || params.PasswordCommand != "" {
+			delete(c.vault.Secrets, id)
+		}
+	}
+
+	for id, params := range c.Connections {
+		if params.Password == "" {
+			continue
+		}
+		if err := c.vault.unlock(c.Vault); err != nil {
+			return err
+		}
+		sealed, err := c.vault.seal(id, params.Password)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt password for '%s': %w", id, err)
+		}
+		c.vault.Secrets[id] = sealed
+		params.Password = ""
+		c.Connections[id] = params
+	}
+
+	return c.vault.save()
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+func TestReadPassphraseLeavesTheRestOfStdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	stdin, stderr := os.Stdin, os.Stderr
+	os.Stdin, os.Stderr = r, devNull
+	defer func() { os.Stdin, os.Stderr = stdin, stderr }()
+
+	if _, err := io.WriteString(w, "correct horse\r\ny\nprod\n"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	passphrase, err := readPassphrase("Vault passphrase: ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(passphrase) != "correct horse" {
+		t.Errorf("got passphrase %q, want %q", passphrase, "correct horse")
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "y\nprod\n" {
+		t.Errorf("got %q left on stdin, want %q", rest, "y\nprod\n")
+	}
+}
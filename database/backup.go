@@ -2,23 +2,59 @@ package database
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"proman/config"
 	"proman/utils"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/briandowns/spinner"
 )
 
-func backupRoles(params config.ConnectionParams, binaries config.BinaryPaths, filename string) (string, error) {
+// dumpEncoding says how dump output is written: in which format, compressed,
+// then encrypted.
+type dumpEncoding struct {
+	format      string
+	jobs        int
+	compression Compression
+	encryption  *Encryption
+}
+
+// extension is the file name suffix for a part, including .sql or .dump.
+func (e dumpEncoding) extension() string {
+	switch e.format {
+	case FormatCustom:
+		return ".dump" + e.encryption.Extension()
+	case FormatDirectory:
+		return ".dir"
+	default:
+		return ".sql" + e.compression.Extension() + e.encryption.Extension()
+	}
+}
+
+// streamCompression is the compression applied to pg_dump's output. Archive
+// formats are compressed by pg_dump instead.
+func (e dumpEncoding) streamCompression() Compression {
+	if e.format == FormatCustom || e.format == FormatDirectory {
+		return Compression{}
+	}
+	return e.compression
+}
+
+// dumpTo runs a dump command with its output going to filename, compressed and
+// encrypted on the way. An empty filename writes to a temporary file instead.
+func dumpTo(cmd *exec.Cmd, binaries config.BinaryPaths, encoding dumpEncoding, filename, tempPattern, what string) (string, error) {
 	var outFile *os.File = nil
 	var err error = nil
 	isTempFile := filename == ""
 
 	if isTempFile {
-		outFile, err = os.CreateTemp("", "tmp_roles_*.sql")
+		outFile, err = os.CreateTemp("", tempPattern+encoding.extension())
 		if err != nil {
 			return "", fmt.Errorf("failed to create temporary output file: %w", err)
 		}
@@ -31,21 +67,40 @@ func backupRoles(params config.ConnectionParams, binaries config.BinaryPaths, fi
 	}
 	defer outFile.Close()
 
-	spin := utils.NewSpinner("Dumping roles to %s", filename)
+	spin := utils.NewSpinner("Dumping %s to %s", what, filename)
 	spin.Start()
 	defer spin.Stop()
 
-	cmd := exec.Command(
-		binaries.PGDumpAll, "--roles-only", "--no-role-passwords", "-h", params.Host, "-p", params.Port, "-U", params.User,
-	)
-	cmd.Env = append(os.Environ(), "PGPASSWORD="+params.Password)
-	cmd.Stdout = outFile
+	var sealed io.WriteCloser = nopWriteCloser{outFile}
+	if encoding.encryption.enabled() {
+		sealed, err = encryptTo(outFile, encoding.encryption)
+	}
+	var out io.WriteCloser
+	if err == nil {
+		out, err = compressTo(sealed, encoding.streamCompression(), binaries)
+	}
+	if err != nil {
+		outFile.Close()
+		if isTempFile {
+			os.Remove(filename)
+		}
+		return "", fmt.Errorf("failed to set up the %s output: %w", what, err)
+	}
+
+	cmd.Stdout = out
 	// cmd.Stderr = os.Stderr TODO add log file
 
 	err = cmd.Run()
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := sealed.Close(); err == nil {
+		err = closeErr
+	}
 
 	if err != nil {
 		if isTempFile {
+			outFile.Close()
 			os.Remove(filename)
 		}
 		return "", err
@@ -54,34 +109,17 @@ func backupRoles(params config.ConnectionParams, binaries config.BinaryPaths, fi
 	return filename, nil
 }
 
-func backupSchema(params config.ConnectionParams, binaries config.BinaryPaths, filename string) (string, error) {
-	var outFile *os.File = nil
-	var err error = nil
-	isTempFile := filename == ""
-
-	if isTempFile {
-		outFile, err = os.CreateTemp("", "tmp_schema_*.sql")
-		if err != nil {
-			return "", fmt.Errorf("failed to create temporary output file: %w", err)
-		}
-		filename = outFile.Name()
-	} else {
-		outFile, err = os.Create(filename)
-		if err != nil {
-			return "", fmt.Errorf("failed to create output file: %w", err)
-		}
-	}
-	defer outFile.Close()
-
-	spin := utils.NewSpinner("Dumping schema to %s", filename)
-	spin.Start()
-	defer spin.Stop()
+func backupRoles(params config.ConnectionParams, binaries config.BinaryPaths, encoding dumpEncoding, filename string) (string, error) {
+	cmd := exec.Command(
+		binaries.PGDumpAll, "--roles-only", "--no-role-passwords", "-h", params.Host, "-p", params.Port, "-U", params.User,
+	)
+	cmd.Env = connectionEnv(params)
 
-	excludedSchemas := []string{
-		"auth", "cron", "extensions", "graphql", "graphql_public", "net", "pgbouncer", "pgsodium", "pgsodium_masks",
-		"realtime", "storage", "supabase_functions", "supabase_migrations", "vault", "_realtime",
-	}
+	return dumpTo(cmd, binaries, encoding, filename, "tmp_roles_*", "roles")
+}
 
+// backupSchema dumps the schema, as of an exported snapshot when one is given.
+func backupSchema(params config.ConnectionParams, binaries config.BinaryPaths, excludedSchemas []string, snapshot string, encoding dumpEncoding, filename string) (string, error) {
 	args := []string{
 		"-h", params.Host,
 		"-p", params.Port,
@@ -94,78 +132,52 @@ func backupSchema(params config.ConnectionParams, binaries config.BinaryPaths, f
 	for _, s := range excludedSchemas {
 		args = append(args, "--exclude-schema="+s)
 	}
+	if snapshot != "" {
+		args = append(args, "--snapshot="+snapshot)
+	}
+	args = append(args, archiveArgs(encoding)...)
 
 	cmd := exec.Command(binaries.PGDump, args...)
-	cmd.Env = append(os.Environ(), "PGPASSWORD="+params.Password)
-	cmd.Stdout = outFile
-	// cmd.Stderr = os.Stderr TODO add log file
-
-	err = cmd.Run()
+	cmd.Env = connectionEnv(params)
 
-	if err != nil {
-		if isTempFile {
-			os.Remove(filename)
-		}
-		return "", err
+	switch {
+	case encoding.format == FormatDirectory:
+		return dumpDirectory(cmd, filename, "tmp_schema_*", "schema")
+	case encoding.format == FormatCustom && !encoding.encryption.enabled():
+		return dumpArchive(cmd, filename, "tmp_schema_*", "schema")
 	}
-
-	return filename, nil
+	return dumpTo(cmd, binaries, encoding, filename, "tmp_schema_*", "schema")
 }
 
-func backupData(params config.ConnectionParams, binaries config.BinaryPaths, filename string) (string, error) {
-	var outFile *os.File = nil
-	var err error = nil
-	isTempFile := filename == ""
-
-	if isTempFile {
-		outFile, err = os.CreateTemp("", "tmp_data_*.sql")
-		if err != nil {
-			return "", fmt.Errorf("failed to create temporary output file: %w", err)
-		}
-		filename = outFile.Name()
-	} else {
-		outFile, err = os.Create(filename)
-		if err != nil {
-			return "", fmt.Errorf("failed to create output file: %w", err)
-		}
-	}
-	defer outFile.Close()
-
-	spin := utils.NewSpinner("Dumping data to %s", filename)
-	spin.Start()
-	defer spin.Stop()
-
-	excludedSchemas := []string{
-		"auth", "cron", "extensions", "graphql", "graphql_public", "net", "pgbouncer", "pgsodium", "pgsodium_masks",
-		"realtime", "storage", "supabase_functions", "supabase_migrations", "vault", "_realtime",
-	}
-
+func backupData(params config.ConnectionParams, binaries config.BinaryPaths, excludedSchemas []string, snapshot string, encoding dumpEncoding, filename string) (string, error) {
 	args := []string{
 		"-h", params.Host,
 		"-p", params.Port,
 		"-U", params.User,
 		"-d", params.DBName,
 		"--data-only",
-		"--quote-all-identifiers",
+	}
+	if encoding.format == FormatPlain || encoding.format == "" {
+		args = append(args, "--quote-all-identifiers")
 	}
 	for _, s := range excludedSchemas {
 		args = append(args, "--exclude-schema="+s)
 	}
+	if snapshot != "" {
+		args = append(args, "--snapshot="+snapshot)
+	}
+	args = append(args, archiveArgs(encoding)...)
 
 	cmd := exec.Command(binaries.PGDump, args...)
-	cmd.Env = append(os.Environ(), "PGPASSWORD="+params.Password)
-	cmd.Stdout = outFile
-	// cmd.Stderr = os.Stderr TODO add log file
+	cmd.Env = connectionEnv(params)
 
-	err = cmd.Run()
-	if err != nil {
-		if isTempFile {
-			os.Remove(filename)
-		}
-		return "", err
+	switch {
+	case encoding.format == FormatDirectory:
+		return dumpDirectory(cmd, filename, "tmp_data_*", "data")
+	case encoding.format == FormatCustom && !encoding.encryption.enabled():
+		return dumpArchive(cmd, filename, "tmp_data_*", "data")
 	}
-
-	return filename, nil
+	return dumpTo(cmd, binaries, encoding, filename, "tmp_data_*", "data")
 }
 
 type OfficialType int
@@ -217,105 +229,395 @@ func officialBackup(params config.ConnectionParams, binaries config.BinaryPaths,
 	return cmd.Run()
 }
 
+type backupOptions struct {
+	setName                               string
+	doRoles, doSchema, doData, doOfficial bool
+	noSnapshot                            bool
+	// compress overrides the connection's compression when set
+	compress      string
+	compressLevel int
+	// format and jobs override the connection's when set
+	format string
+	jobs   int
+	// recipients and passphrase add to the connection's recipients
+	encrypt    bool
+	recipients []string
+	passphrase []byte
+}
+
 func Backup(cfg *config.Config, args []string) error {
 	if len(args) < 1 {
 		return fmt.Errorf("backup command requires at least a project ID")
 	}
 
-	var projectID string
-	var filePrefix string
-	doRoles, doSchema, doData, doOfficial := false, false, false, false
+	var selectors, tags []string
+	opts := backupOptions{}
+	usePassphrase := false
 
 	for i := 0; i < len(args); i++ {
 		arg := args[i]
 		switch {
 		case arg == "--official":
-			doOfficial = true
+			opts.doOfficial = true
 		case arg == "--roles":
-			doRoles = true
+			opts.doRoles = true
 		case arg == "--schema":
-			doSchema = true
+			opts.doSchema = true
 		case arg == "--data":
-			doData = true
-		case arg == "--prefix":
+			opts.doData = true
+		case arg == "--name" || arg == "--prefix":
 			if i+1 < len(args) {
-				filePrefix = args[i+1]
+				opts.setName = args[i+1]
 				i++
 			} else {
-				return fmt.Errorf("--prefix flag requires a value")
+				return fmt.Errorf("%s flag requires a value", arg)
+			}
+		case arg == "--compress":
+			if i+1 < len(args) {
+				opts.compress = args[i+1]
+				i++
+			} else {
+				return fmt.Errorf("--compress flag requires a value")
+			}
+		case arg == "--compress-level":
+			if i+1 < len(args) {
+				level, err := strconv.Atoi(args[i+1])
+				if err != nil {
+					return fmt.Errorf("invalid compression level '%s'", args[i+1])
+				}
+				opts.compressLevel = level
+				i++
+			} else {
+				return fmt.Errorf("--compress-level flag requires a value")
+			}
+		case arg == "--no-snapshot":
+			opts.noSnapshot = true
+		case arg == "--format":
+			if i+1 < len(args) {
+				format, err := ParseFormat(args[i+1])
+				if err != nil {
+					return err
+				}
+				opts.format = format
+				i++
+			} else {
+				return fmt.Errorf("--format flag requires a value")
+			}
+		case arg == "--jobs":
+			if i+1 < len(args) {
+				jobs, err := strconv.Atoi(args[i+1])
+				if err != nil || jobs < 1 {
+					return fmt.Errorf("invalid number of jobs '%s'", args[i+1])
+				}
+				opts.jobs = jobs
+				i++
+			} else {
+				return fmt.Errorf("--jobs flag requires a value")
+			}
+		case arg == "--encrypt":
+			opts.encrypt = true
+		case arg == "--recipient":
+			if i+1 < len(args) {
+				if _, err := ParseRecipient(args[i+1]); err != nil {
+					return err
+				}
+				opts.recipients = append(opts.recipients, args[i+1])
+				opts.encrypt = true
+				i++
+			} else {
+				return fmt.Errorf("--recipient flag requires a value")
+			}
+		case arg == "--passphrase":
+			usePassphrase = true
+			opts.encrypt = true
+		case arg == "--tag":
+			if i+1 < len(args) {
+				tags = append(tags, args[i+1])
+				i++
+			} else {
+				return fmt.Errorf("--tag flag requires a value")
 			}
 		case !strings.HasPrefix(arg, "--"):
-			projectID = arg
+			selectors = append(selectors, arg)
 		default:
 			return fmt.Errorf("unknown flag: %s", arg)
 		}
 	}
 
-	if projectID == "" {
+	if len(selectors) == 0 && len(tags) == 0 {
 		return fmt.Errorf("no project ID specified")
 	}
+	if opts.compress != "" {
+		if _, err := ParseCompression(opts.compress, opts.compressLevel); err != nil {
+			return err
+		}
+	}
+
+	targets, err := cfg.ResolveTargets(selectors, tags)
+	if err != nil {
+		return err
+	}
+	if usePassphrase {
+		// asked for once, every target is encrypted with the same passphrase
+		opts.passphrase, err = backupPassphrase(true)
+		if err != nil {
+			return err
+		}
+	}
+
+	isFullBackup := !opts.doRoles && !opts.doSchema && !opts.doData
+	if isFullBackup {
+		opts.doRoles, opts.doSchema, opts.doData = true, true, true
+	}
+
+	var results []*Result
+	run := func(projectID string, opts backupOptions) error {
+		result := newResult("backup", projectID)
+		err := backupProject(cfg, projectID, opts, result)
+		results = append(results, result.finish(err))
+		return err
+	}
+
+	if isSingleTarget(selectors, tags) {
+		err := run(targets[0], opts)
+		return printResults(err, results[0])
+	}
 
-	if filePrefix == "" {
-		timestamp := time.Now().Format("2006-01-02_15-04-05")
-		filePrefix = fmt.Sprintf("%s_%s", projectID, timestamp)
+	err = forEachTarget(targets, func(projectID string) error {
+		return run(projectID, opts)
+	})
+	return printResults(err, results)
+}
+
+func backupProject(cfg *config.Config, projectID string, opts backupOptions, result *Result) error {
+	started := time.Now()
+	setName := opts.setName
+	if setName == "" {
+		setName = started.Format("2006-01-02_15-04-05")
 	}
 
-	params, found := cfg.GetConnection(projectID)
+	configured, found := cfg.GetConnection(projectID)
 	if !found {
 		return fmt.Errorf("project with ID '%s' not found", projectID)
 	}
+	params, err := PrepareConnection(cfg, projectID, configured, OpDump)
+	if err != nil {
+		return err
+	}
 	binaries := cfg.GetBinaryPaths()
 	if binaries.PSQL == "" || binaries.PGDump == "" || binaries.PGDumpAll == "" {
 		return fmt.Errorf("one or more PostgreSQL binary paths are not set in the config")
 	}
+	if !opts.doOfficial {
+		binaries, err = binariesFor(projectID, params, binaries)
+		if err != nil {
+			return err
+		}
+	}
 
-	isFullBackup := !doRoles && !doSchema && !doData
-	if isFullBackup {
-		doRoles, doSchema, doData = true, true, true
+	compression, err := backupCompression(configured, opts)
+	if err != nil {
+		return fmt.Errorf("invalid compression for '%s': %w", projectID, err)
+	}
+	if opts.doOfficial && compression.Method != CompressNone {
+		utils.WarningPrint("The supabase CLI writes its own files, so the --official backup of '%s' is not compressed\n", projectID)
+		compression = Compression{}
+	}
+	encryption, err := backupEncryption(configured, opts)
+	if err != nil {
+		return fmt.Errorf("cannot encrypt the backup of '%s': %w", projectID, err)
+	}
+	if opts.doOfficial && encryption.enabled() {
+		return fmt.Errorf("the supabase CLI writes its own files, so --official backups of '%s' can't be encrypted", projectID)
+	}
+	format := opts.format
+	if format == "" {
+		format, err = ParseFormat(configured.Format)
+		if err != nil {
+			return fmt.Errorf("invalid format for '%s': %w", projectID, err)
+		}
+	}
+	if opts.doOfficial && format != FormatPlain {
+		return fmt.Errorf("the supabase CLI only writes plain SQL, so --official backups of '%s' can't use the %s format", projectID, format)
+	}
+	if format == FormatDirectory && encryption.enabled() {
+		return fmt.Errorf("directory format backups of '%s' can't be encrypted, use the custom format", projectID)
+	}
+	jobs := opts.jobs
+	if jobs == 0 {
+		jobs = defaultJobs(configured)
+	}
+	encoding := dumpEncoding{format, jobs, compression, encryption}
+	if !opts.doOfficial && (opts.doSchema || opts.doData) {
+		if err := checkArchiveCompression(binaries, encoding); err != nil {
+			return fmt.Errorf("cannot back up '%s': %w", projectID, err)
+		}
 	}
 
-	if doOfficial {
-		// make sure the supabase docker containers get cloes after every backup finishes
+	// schema and data come from one snapshot, so a migration landing between
+	// the two dumps can't make them disagree
+	var snapshot *heldSnapshot
+	if !opts.doOfficial && !opts.noSnapshot && (opts.doSchema || opts.doData) {
+		snapshot, err = exportSnapshot(params, binaries)
+		if err != nil {
+			return fmt.Errorf("failed to export a snapshot of '%s', pass --no-snapshot to dump without one: %w", projectID, err)
+		}
 		defer func() {
-			stopCmd := exec.Command(binaries.Supabase, "stop")
-			stopCmd.Run()
+			if err := snapshot.release(); err != nil {
+				utils.WarningPrint("Could not end the snapshot transaction on '%s': %v\n", projectID, err)
+			}
 		}()
 	}
 
-	if doRoles {
-		if doOfficial {
-			if err := officialBackup(params, binaries, filePrefix+"_roles_official.sql", ROLES_ONLY); err != nil {
-				return fmt.Errorf("failed to backup roles: %w", err)
-			}
-		} else {
-			if _, err := backupRoles(params, binaries, filePrefix+"_roles.sql"); err != nil {
-				return fmt.Errorf("failed to backup roles: %w", err)
-			}
+	dir := filepath.Join(cfg.GetBackupRoot(), projectID, setName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	manifest := &Manifest{
+		ProjectID:       projectID,
+		Host:            configured.Host,
+		Database:        params.DBName,
+		Official:        opts.doOfficial,
+		Parts:           []ManifestPart{},
+		ExcludedSchemas: []string{},
+		StartedAt:       started,
+		PromanVersion:   utils.ProgramVersion(),
+	}
+	if manifest.Host == "" {
+		manifest.Host = params.Host
+	}
+	if snapshot != nil {
+		manifest.Snapshot = snapshot.ID
+		manifest.SnapshotLSN = snapshot.LSN
+	}
+	if encryption.enabled() {
+		manifest.Encryption = &ManifestEncryption{
+			Recipients: encryption.Recipients,
+			Passphrase: encryption.passphrase != nil,
 		}
 	}
-	if doSchema {
-		if doOfficial {
-			if err := officialBackup(params, binaries, (filePrefix + "_schema_official.sql"), SCHEMA_ONLY); err != nil {
-				return fmt.Errorf("failed to backup schema: %w", err)
-			}
-		} else {
-			if _, err := backupSchema(params, binaries, (filePrefix + "_schema.sql")); err != nil {
-				return fmt.Errorf("failed to backup schema: %w", err)
-			}
+	if version, err := ServerVersion(params, binaries); err == nil {
+		manifest.ServerVersion = version
+	} else {
+		utils.WarningPrint("Could not read the server version of '%s': %v\n", projectID, err)
+	}
+	if !opts.doOfficial {
+		// the supabase CLI applies its own exclusions
+		manifest.ExcludedSchemas = cfg.GetExcludedSchemas()
+		if version, err := utils.BinaryVersion("pg_dump", binaries.PGDump); err == nil {
+			manifest.PGDumpVersion = version
 		}
 	}
-	if doData {
-		if doOfficial {
-			if err := officialBackup(params, binaries, filePrefix+"_data_official.sql", DATA_ONLY); err != nil {
-				return fmt.Errorf("failed to backup data: %w", err)
-			}
-		} else {
-			if _, err := backupData(params, binaries, filePrefix+"_data.sql"); err != nil {
-				return fmt.Errorf("failed to backup data: %w", err)
-			}
+
+	err = dumpParts(cfg, projectID, params, binaries, opts, encoding, manifest.Snapshot, dir, manifest, result)
+
+	manifest.FinishedAt = time.Now()
+	manifest.Status = ManifestComplete
+	if err != nil {
+		manifest.Status = ManifestFailed
+		manifest.Error = err.Error()
+	}
+	if writeErr := writeManifest(dir, manifest); writeErr != nil && err == nil {
+		err = fmt.Errorf("failed to write the backup manifest: %w", writeErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.RecordBackup(projectID, manifest.FinishedAt); err != nil {
+		utils.WarningPrint("Could not record the backup time: %v\n", err)
+	}
+	utils.SuccessPrint("Backup complete: %s\n", dir)
+	return nil
+}
+
+// backupCompression picks the --compress flag over the connection's default.
+func backupCompression(params config.ConnectionParams, opts backupOptions) (Compression, error) {
+	method, level := params.Compress, params.CompressLevel
+	if opts.compress != "" {
+		method, level = opts.compress, opts.compressLevel
+	} else if opts.compressLevel != 0 {
+		level = opts.compressLevel
+	}
+	return ParseCompression(method, level)
+}
+
+// backupEncryption collects the connection's recipients and those given with
+// the backup. Connections with recipients are always encrypted.
+func backupEncryption(params config.ConnectionParams, opts backupOptions) (*Encryption, error) {
+	encryption := &Encryption{passphrase: opts.passphrase}
+	for _, recipient := range append(append([]string{}, params.Recipients...), opts.recipients...) {
+		if _, err := ParseRecipient(recipient); err != nil {
+			return nil, err
+		}
+		if !slices.Contains(encryption.Recipients, recipient) {
+			encryption.Recipients = append(encryption.Recipients, recipient)
 		}
 	}
+	if !encryption.enabled() {
+		if opts.encrypt {
+			return nil, fmt.Errorf("no recipients, add them with 'connection register --recipient' or pass --recipient or --passphrase")
+		}
+		return nil, nil
+	}
+	return encryption, nil
+}
+
+type backupPart struct {
+	name     string
+	official OfficialType
+	encoding dumpEncoding
+	dump     func(filename string) (string, error)
+}
+
+// dumpParts writes each selected part into the set directory and adds it to
+// the manifest.
+func dumpParts(cfg *config.Config, projectID string, params config.ConnectionParams, binaries config.BinaryPaths, opts backupOptions, encoding dumpEncoding, snapshot, dir string, manifest *Manifest, result *Result) error {
+	excludedSchemas := cfg.GetExcludedSchemas()
+
+	var parts []backupPart
+	// pg_dumpall only writes plain SQL
+	rolesEncoding := encoding
+	rolesEncoding.format = FormatPlain
+	if opts.doRoles {
+		parts = append(parts, backupPart{"roles", ROLES_ONLY, rolesEncoding, func(filename string) (string, error) {
+			return backupRoles(params, binaries, rolesEncoding, filename)
+		}})
+	}
+	if opts.doSchema {
+		parts = append(parts, backupPart{"schema", SCHEMA_ONLY, encoding, func(filename string) (string, error) {
+			return backupSchema(params, binaries, excludedSchemas, snapshot, encoding, filename)
+		}})
+	}
+	if opts.doData {
+		parts = append(parts, backupPart{"data", DATA_ONLY, encoding, func(filename string) (string, error) {
+			return backupData(params, binaries, excludedSchemas, snapshot, encoding, filename)
+		}})
+	}
 
-	utils.SuccessPrint("Backup complete\n")
+	if opts.doOfficial {
+		// make sure the supabase docker containers get cloes after every backup finishes
+		defer func() {
+			stopCmd := exec.Command(binaries.Supabase, "stop")
+			stopCmd.Run()
+		}()
+	}
+
+	for _, part := range parts {
+		path, err := result.record(projectID, part.name, func() (string, error) {
+			filename := filepath.Join(dir, part.name+part.encoding.extension())
+			if opts.doOfficial {
+				return filename, officialBackup(params, binaries, filename, part.official)
+			}
+			return part.dump(filename)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to backup %s: %w", part.name, err)
+		}
+		if err := manifest.addPart(part.name, path, part.encoding); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -89,10 +89,18 @@ func GenMigration(cfg *config.Config, args []string) error {
 	if !found {
 		return fmt.Errorf("source project with ID '%s' not found", sourceID)
 	}
+	sourceParams, err := PrepareConnection(cfg, sourceID, sourceParams, OpMigration)
+	if err != nil {
+		return err
+	}
 	targetParams, found := cfg.GetConnection(targetID)
 	if !found {
 		return fmt.Errorf("target project with ID '%s' not found", targetID)
 	}
+	targetParams, err = PrepareConnection(cfg, targetID, targetParams, OpMigration)
+	if err != nil {
+		return err
+	}
 
 	binaries := cfg.GetBinaryPaths()
 	spin := utils.NewSpinner("Generating diff: %s -> %s", sourceID, targetID)
@@ -115,6 +123,7 @@ func GenMigration(cfg *config.Config, args []string) error {
 
 func Clone(cfg *config.Config, args []string) error {
 	var sourceID, targetID string
+	overrideProtection := false
 
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
@@ -132,6 +141,8 @@ func Clone(cfg *config.Config, args []string) error {
 			} else {
 				return fmt.Errorf("--target flag requires a value")
 			}
+		case OverrideProtectionFlag:
+			overrideProtection = true
 		default:
 			return fmt.Errorf("unknown flag: %s", args[i])
 		}
@@ -141,20 +152,50 @@ func Clone(cfg *config.Config, args []string) error {
 		return fmt.Errorf("both --source and --target flags are required")
 	}
 
+	result := newResult("clone", targetID)
+	result.Source = sourceID
+	err := cloneProjects(cfg, sourceID, targetID, overrideProtection, result)
+	return printResults(err, result.finish(err))
+}
+
+func cloneProjects(cfg *config.Config, sourceID, targetID string, overrideProtection bool, result *Result) error {
 	sourceParams, found := cfg.GetConnection(sourceID)
 	if !found {
 		return fmt.Errorf("source project with ID '%s' not found", sourceID)
 	}
+	sourceParams, err := PrepareConnection(cfg, sourceID, sourceParams, OpMigration)
+	if err != nil {
+		return err
+	}
 	targetParams, found := cfg.GetConnection(targetID)
 	if !found {
 		return fmt.Errorf("target project with ID '%s' not found", targetID)
 	}
+	// refuse before the backups and migration when the write could never be confirmed
+	if err := canConfirmWrite(targetID, targetParams, overrideProtection); err != nil {
+		return err
+	}
+	targetParams, err = PrepareConnection(cfg, targetID, targetParams, OpMigration)
+	if err != nil {
+		return err
+	}
 
 	binaries := cfg.GetBinaryPaths()
 	if binaries.PSQL == "" || binaries.PGDump == "" || binaries.PGDumpAll == "" {
 		return fmt.Errorf("one or more required binaries (psql, pg_dump, pg_dumpall) are not set in the config")
 	}
 
+	// fail before taking any backup if either server has no matching pg_dump.
+	// The backups pick the same tools themselves; the target's psql applies
+	// the migration
+	if _, err := binariesFor(sourceID, sourceParams, binaries); err != nil {
+		return err
+	}
+	targetBinaries, err := binariesFor(targetID, targetParams, binaries)
+	if err != nil {
+		return err
+	}
+
 	timestamp := time.Now().Format("2006-01-02_15-04-05")
 
 	spin := utils.NewSpinner("Backing up source project '%s'\n", sourceID)
@@ -163,8 +204,8 @@ func Clone(cfg *config.Config, args []string) error {
 		spin.Stop()
 	}()
 
-	sourcePrefix := fmt.Sprintf("%s_clone_backup_%s", sourceID, timestamp)
-	if err := Backup(cfg, []string{sourceID, "--prefix", sourcePrefix}); err != nil {
+	fullBackup := backupOptions{setName: timestamp + "_clone", doRoles: true, doSchema: true, doData: true}
+	if err := backupProject(cfg, sourceID, fullBackup, result); err != nil {
 		return fmt.Errorf("failed to backup source project '%s': %w", sourceID, err)
 	}
 
@@ -172,8 +213,7 @@ func Clone(cfg *config.Config, args []string) error {
 	spin = utils.NewSpinner("Backing up target project '%s'", targetID)
 	spin.Start()
 
-	targetPrefix := fmt.Sprintf("%s_clone_backup_%s", targetID, timestamp)
-	if err := Backup(cfg, []string{targetID, "--prefix", targetPrefix}); err != nil {
+	if err := backupProject(cfg, targetID, fullBackup, result); err != nil {
 		return fmt.Errorf("failed to backup target project '%s': %w", targetID, err)
 	}
 
@@ -188,6 +228,7 @@ func Clone(cfg *config.Config, args []string) error {
 
 	if len(migrationScript) == 0 {
 		utils.WarningPrint("No clone needed\n")
+		result.setChanged(false)
 		return nil
 	}
 
@@ -207,7 +248,7 @@ func Clone(cfg *config.Config, args []string) error {
 	utils.InfoPrint("Opening migration script in `less` for review (press 'q' to quit)... ")
 
 	lessCmd := exec.Command("less", tmpfile.Name())
-	lessCmd.Stdout = os.Stdout
+	lessCmd.Stdout = utils.CommandOutput()
 	lessCmd.Stderr = os.Stderr
 	lessCmd.Stdin = os.Stdin
 
@@ -225,21 +266,34 @@ func Clone(cfg *config.Config, args []string) error {
 
 	if strings.TrimSpace(strings.ToLower(response)) != "y" {
 		utils.ErrorPrint("Migration cancelled by user\n")
+		result.Status = "cancelled"
+		result.setChanged(false)
 		return nil
 	}
 
+	if err := confirmWrite(targetID, targetParams, overrideProtection); err != nil {
+		return err
+	}
+
 	spin.Stop()
 	spin = utils.NewSpinner("Applying migrations")
 	spin.Start()
 
-	targetURL := FormatRemoteConnectionString(targetParams)
-	applyCmd := exec.Command(binaries.PSQL, "-d", targetURL, "-c", migrationScript)
+	// the script is read from the reviewed file in one transaction, and the
+	// password and TLS settings go through the environment, not the command line
+	applyCmd := exec.Command(
+		targetBinaries.PSQL, "-X", "-v", "ON_ERROR_STOP=1", "--single-transaction",
+		"-h", targetParams.Host, "-p", targetParams.Port, "-U", targetParams.User, "-d", targetParams.DBName,
+		"-f", tmpfile.Name(),
+	)
+	applyCmd.Env = connectionEnv(targetParams)
 	applyCmd.Stderr = os.Stderr
-	applyCmd.Stdout = os.Stdout
+	applyCmd.Stdout = utils.CommandOutput()
 	err = applyCmd.Run()
 	if err != nil {
 		return fmt.Errorf("failed to apply migration: %w", err)
 	}
+	result.setChanged(true)
 
 	utils.SuccessPrint("Migration applied successfully\n")
 	return nil
@@ -0,0 +1,257 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"proman/config"
+	"strconv"
+)
+
+const (
+	CompressNone = ""
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+// Compression says how dump output is compressed while it is written.
+type Compression struct {
+	Method string
+	Level  int
+}
+
+func ParseCompression(method string, level int) (Compression, error) {
+	switch method {
+	case "", "none":
+		return Compression{}, nil
+	case CompressGzip:
+		if level != 0 && (level < gzip.BestSpeed || level > gzip.BestCompression) {
+			return Compression{}, fmt.Errorf("gzip level must be between 1 and 9, got %d", level)
+		}
+	case CompressZstd:
+		if level != 0 && (level < 1 || level > 19) {
+			return Compression{}, fmt.Errorf("zstd level must be between 1 and 19, got %d", level)
+		}
+	default:
+		return Compression{}, fmt.Errorf("unknown compression '%s', expected gzip, zstd or none", method)
+	}
+	return Compression{Method: method, Level: level}, nil
+}
+
+// Extension is appended to the file name of a compressed dump.
+func (c Compression) Extension() string {
+	switch c.Method {
+	case CompressGzip:
+		return ".gz"
+	case CompressZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+func zstdPath(binaries config.BinaryPaths) string {
+	if binaries.Zstd != "" {
+		return binaries.Zstd
+	}
+	return "zstd"
+}
+
+// compressTo returns a writer that compresses into w. Closing it flushes the
+// compressor, but leaves w open.
+func compressTo(w io.Writer, c Compression, binaries config.BinaryPaths) (io.WriteCloser, error) {
+	switch c.Method {
+	case CompressGzip:
+		level := c.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case CompressZstd:
+		level := c.Level
+		if level == 0 {
+			level = 3
+		}
+		cmd := exec.Command(zstdPath(binaries), "-q", "-c", "-T0", "-"+strconv.Itoa(level))
+		cmd.Stdout = w
+		return startPipe(cmd)
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// pipeWriter feeds an external compressor and waits for it on Close.
+type pipeWriter struct {
+	io.WriteCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+func startPipe(cmd *exec.Cmd) (io.WriteCloser, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", cmd.Path, err)
+	}
+	return &pipeWriter{stdin, cmd, &stderr}, nil
+}
+
+func (p *pipeWriter) Close() error {
+	closeErr := p.WriteCloser.Close()
+	if err := p.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s failed: %w: %s", p.cmd.Args[0], err, bytes.TrimSpace(p.stderr.Bytes()))
+	}
+	return closeErr
+}
+
+// peekCompression tells a compressed dump apart by its magic bytes, so files
+// are read correctly whatever they are named.
+func peekCompression(r *bufio.Reader) string {
+	magic, _ := r.Peek(4)
+	switch {
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		return CompressGzip
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return CompressZstd
+	default:
+		return CompressNone
+	}
+}
+
+// isPlainDump reports whether a dump file is neither encrypted nor compressed,
+// so tools can read it directly.
+func isPlainDump(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	return !isEncrypted(r) && peekCompression(r) == CompressNone, nil
+}
+
+// openDump opens a dump file for reading, decrypting it with keys and
+// decompressing it as needed.
+func openDump(path string, binaries config.BinaryPaths, keys *Keyring) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(f)
+	if isEncrypted(r) {
+		if keys == nil {
+			keys = &Keyring{}
+		}
+		plain, err := keys.decrypt(r, path)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		r = bufio.NewReader(plain)
+	}
+
+	switch peekCompression(r) {
+	case CompressGzip:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		return &gzipReader{gz, f}, nil
+	case CompressZstd:
+		cmd := exec.Command(zstdPath(binaries), "-q", "-d", "-c")
+		cmd.Stdin = r
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to start %s: %w", cmd.Path, err)
+		}
+		return &pipeReader{stdout, cmd, &stderr, f}, nil
+	default:
+		return &bufferedFile{r, f}, nil
+	}
+}
+
+type bufferedFile struct {
+	*bufio.Reader
+	file *os.File
+}
+
+func (b *bufferedFile) Close() error {
+	return b.file.Close()
+}
+
+type gzipReader struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipReader) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+// pipeReader reads from an external decompressor and waits for it on Close.
+type pipeReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	file   *os.File
+}
+
+func (p *pipeReader) Close() error {
+	// drain so the decompressor can finish and report corrupt input
+	io.Copy(io.Discard, p.ReadCloser)
+	err := p.cmd.Wait()
+	p.file.Close()
+	if err != nil {
+		return fmt.Errorf("%s failed: %w: %s", p.cmd.Args[0], err, bytes.TrimSpace(p.stderr.Bytes()))
+	}
+	return nil
+}
+
+// decompressToTemp writes the plain contents of a dump to a temporary file.
+func decompressToTemp(path, pattern string, binaries config.BinaryPaths, keys *Keyring) (string, error) {
+	in, err := openDump(path, binaries, keys)
+	if err != nil {
+		return "", err
+	}
+	out, err := os.CreateTemp("", pattern)
+	if err != nil {
+		in.Close()
+		return "", fmt.Errorf("failed to create temporary output file: %w", err)
+	}
+
+	_, err = io.Copy(out, in)
+	if closeErr := in.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+	return out.Name(), nil
+}
@@ -1,36 +1,136 @@
 package database
 
 import (
+	"bytes"
 	"fmt"
+	"os"
+	"path/filepath"
 	"proman/config"
 	"proman/utils"
+	"strings"
 )
 
 func Diff(cfg *config.Config, args []string) error {
-	var err error = nil
-	if len(args) != 2 {
-		return fmt.Errorf("diff command requires exactly two project IDs (source and target)")
+	var ids, identityFiles []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--identity":
+			if i+1 < len(args) {
+				identityFiles = append(identityFiles, args[i+1])
+				i++
+			} else {
+				return fmt.Errorf("--identity flag requires a value")
+			}
+		case !strings.HasPrefix(arg, "--"):
+			ids = append(ids, arg)
+		default:
+			return fmt.Errorf("unknown flag: %s", arg)
+		}
 	}
-	sourceID := args[0]
-	targetID := args[1]
-
-	sourceParams, found := cfg.GetConnection(sourceID)
-	if !found {
-		return fmt.Errorf("source project with ID '%s' not found", sourceID)
+	if len(ids) != 2 {
+		return fmt.Errorf("diff command requires exactly two project IDs or backup sets (source and target)")
 	}
-	targetParams, found := cfg.GetConnection(targetID)
-	if !found {
-		return fmt.Errorf("target project with ID '%s' not found", targetID)
+	sourceID := ids[0]
+	targetID := ids[1]
+
+	// identities only matter when a side is a backup set
+	var keys *Keyring
+	_, sourceFound := cfg.GetConnection(sourceID)
+	_, targetFound := cfg.GetConnection(targetID)
+	if !sourceFound || !targetFound {
+		var err error
+		keys, err = LoadKeyring(cfg, identityFiles)
+		if err != nil {
+			return err
+		}
 	}
 
-	sourceSchema, err := backupSchema(sourceParams, cfg.Binaries, "")
+	result := newResult("diff", targetID)
+	result.Source = sourceID
+	err := diffProjects(cfg, sourceID, targetID, keys, result)
+	return printResults(err, result.finish(err))
+}
+
+func diffProjects(cfg *config.Config, sourceID, targetID string, keys *Keyring, result *Result) error {
+	sourceSchema, err := schemaFor(cfg, sourceID, keys, result)
 	if err != nil {
-		return fmt.Errorf("could not backup project %s: %w", sourceID, err)
+		return err
 	}
-	targetSchema, err := backupSchema(targetParams, cfg.Binaries, "")
+	targetSchema, err := schemaFor(cfg, targetID, keys, result)
 	if err != nil {
-		return fmt.Errorf("could not backup project %s: %w", sourceID, err)
+		return err
+	}
+
+	// scripts get the dumps and whether they differ instead of a viewer
+	if utils.MachineOutput() {
+		sourceData, err := os.ReadFile(sourceSchema)
+		if err != nil {
+			return err
+		}
+		targetData, err := os.ReadFile(targetSchema)
+		if err != nil {
+			return err
+		}
+		result.setChanged(!bytes.Equal(normalizeDump(sourceData), normalizeDump(targetData)))
+		return nil
 	}
 
 	return utils.OpenDiff(targetSchema, sourceSchema, "Target", "Source", cfg)
 }
+
+// normalizeDump drops comments and the per-dump \restrict keys newer pg_dump
+// versions write, so two dumps of the same schema compare equal.
+func normalizeDump(data []byte) []byte {
+	var out bytes.Buffer
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("--")) || bytes.HasPrefix(line, []byte("\\restrict")) || bytes.HasPrefix(line, []byte("\\unrestrict")) {
+			continue
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// schemaFor dumps the schema of a connection, or when id isn't one, extracts
+// the schema part of a backup set.
+func schemaFor(cfg *config.Config, id string, keys *Keyring, result *Result) (string, error) {
+	params, found := cfg.GetConnection(id)
+	if !found {
+		set, err := findBackupSet(cfg.GetBackupRoot(), id)
+		if err != nil {
+			return "", fmt.Errorf("'%s' is neither a project ID nor a backup set", id)
+		}
+		part, found := set.manifest.Part("schema")
+		if !found {
+			return "", fmt.Errorf("backup set %s has no schema", set.dir)
+		}
+		if err := verifyPart(set.dir, part); err != nil {
+			return "", err
+		}
+		return result.record(set.manifest.ProjectID, "schema", func() (string, error) {
+			path := filepath.Join(set.dir, part.File)
+			if part.Format != "" {
+				return archiveToSQL(path, "tmp_schema_*.sql", cfg.GetBinaryPaths(), keys)
+			}
+			return decompressToTemp(path, "tmp_schema_*.sql", cfg.GetBinaryPaths(), keys)
+		})
+	}
+
+	params, err := PrepareConnection(cfg, id, params, OpDump)
+	if err != nil {
+		return "", err
+	}
+	binaries, err := binariesFor(id, params, cfg.GetBinaryPaths())
+	if err != nil {
+		return "", err
+	}
+	schema, err := result.record(id, "schema", func() (string, error) {
+		return backupSchema(params, binaries, cfg.GetExcludedSchemas(), "", dumpEncoding{}, "")
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not backup project %s: %w", id, err)
+	}
+	return schema, nil
+}
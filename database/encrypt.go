@@ -0,0 +1,521 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"proman/config"
+	"proman/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Encrypted backup files follow the layout of age: a text header with one
+// stanza per recipient, each wrapping the same random file key, followed by
+// the payload in 64 KiB chunks sealed with AES-256-GCM. Chunks are numbered
+// and the last one is marked, so reordered or truncated files fail to decrypt.
+// The format is not compatible with the age tool itself.
+const (
+	encryptionMagic = "proman-encryption/v1\n"
+	headerEnd       = "---\n"
+	chunkSize       = 64 * 1024
+
+	recipientPrefix = "promanpub1"
+	identityPrefix  = "PROMAN-SECRET-KEY-1"
+
+	passphraseIterations = 600000
+	// maxPassphraseIterations bounds the work a file's header can ask for, so
+	// a crafted one can't make reading it hang
+	maxPassphraseIterations = 10 * passphraseIterations
+
+	// IdentityFileEnv lists identity files, separated like PATH, tried when
+	// reading encrypted backups.
+	IdentityFileEnv = "PROMAN_IDENTITY_FILE"
+	// BackupPassphraseEnv holds the passphrase for passphrase-encrypted backups.
+	BackupPassphraseEnv = "PROMAN_BACKUP_PASSPHRASE"
+)
+
+var rawBase64 = base64.RawURLEncoding
+
+func ParseRecipient(s string) (*ecdh.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	data, err := rawBase64.DecodeString(strings.TrimPrefix(s, recipientPrefix))
+	if !strings.HasPrefix(s, recipientPrefix) || err != nil {
+		return nil, fmt.Errorf("invalid recipient '%s', expected a key starting with %s", s, recipientPrefix)
+	}
+	key, err := ecdh.X25519().NewPublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient '%s': %w", s, err)
+	}
+	return key, nil
+}
+
+func formatRecipient(key *ecdh.PublicKey) string {
+	return recipientPrefix + rawBase64.EncodeToString(key.Bytes())
+}
+
+func parseIdentity(s string) (*ecdh.PrivateKey, error) {
+	data, err := rawBase64.DecodeString(strings.TrimPrefix(s, identityPrefix))
+	if !strings.HasPrefix(s, identityPrefix) || err != nil {
+		return nil, errors.New("malformed secret key")
+	}
+	return ecdh.X25519().NewPrivateKey(data)
+}
+
+// Encryption says who can read the dump files of a backup.
+type Encryption struct {
+	Recipients []string
+	passphrase []byte
+}
+
+func (e *Encryption) enabled() bool {
+	return e != nil && (len(e.Recipients) > 0 || e.passphrase != nil)
+}
+
+// Extension is appended to the file name of an encrypted dump.
+func (e *Encryption) Extension() string {
+	if e.enabled() {
+		return ".enc"
+	}
+	return ""
+}
+
+// encryptTo writes the header for a new file key to w and returns a writer
+// that seals everything written to it. Closing it writes the final chunk but
+// leaves w open.
+func encryptTo(w io.Writer, e *Encryption) (io.WriteCloser, error) {
+	fileKey := make([]byte, 16)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, err
+	}
+
+	var header bytes.Buffer
+	header.WriteString(encryptionMagic)
+	for _, recipient := range e.Recipients {
+		key, err := ParseRecipient(recipient)
+		if err != nil {
+			return nil, err
+		}
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		shared, err := ephemeral.ECDH(key)
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := wrapKey(shared, append(ephemeral.PublicKey().Bytes(), key.Bytes()...), "X25519", fileKey)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&header, "-> X25519 %s %s\n", rawBase64.EncodeToString(ephemeral.PublicKey().Bytes()), rawBase64.EncodeToString(wrapped))
+	}
+	if e.passphrase != nil {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		derived, err := pbkdf2.Key(sha256.New, string(e.passphrase), salt, passphraseIterations, 32)
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := wrapKey(derived, salt, "passphrase", fileKey)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&header, "-> passphrase %s %d %s\n", rawBase64.EncodeToString(salt), passphraseIterations, rawBase64.EncodeToString(wrapped))
+	}
+	header.WriteString(headerEnd)
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header.Write(nonce)
+	aead, err := payloadCipher(fileKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := header.WriteTo(w); err != nil {
+		return nil, err
+	}
+	return &sealWriter{w: w, aead: aead, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+// wrapKey seals the file key under a key derived from secret.
+func wrapKey(secret, salt []byte, label string, fileKey []byte) ([]byte, error) {
+	aead, err := stanzaCipher(secret, salt, label)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, make([]byte, aead.NonceSize()), fileKey, nil), nil
+}
+
+func stanzaCipher(secret, salt []byte, label string) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, secret, salt, "proman-encryption/v1/"+label, 32)
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(key)
+}
+
+func payloadCipher(fileKey, nonce []byte) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, fileKey, nonce, "proman-encryption/v1/payload", 32)
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(key)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce is the chunk counter with a flag in the last byte that marks the
+// final chunk.
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type sealWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is only sealed once more data shows it isn't the last
+		if len(s.buf) == chunkSize {
+			if err := s.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(s.buf[len(s.buf):chunkSize], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (s *sealWriter) flush(last bool) error {
+	sealed := s.aead.Seal(nil, chunkNonce(s.counter, last), s.buf, nil)
+	s.buf = s.buf[:0]
+	s.counter++
+	_, err := s.w.Write(sealed)
+	return err
+}
+
+func (s *sealWriter) Close() error {
+	return s.flush(true)
+}
+
+// Keyring holds what is available for decrypting backups: the identities
+// from identity files and, once asked for, a passphrase.
+type Keyring struct {
+	identities []*ecdh.PrivateKey
+	passphrase []byte
+}
+
+// LoadKeyring reads the identity files given on the command line, in
+// PROMAN_IDENTITY_FILE and in the config's identity_files.
+func LoadKeyring(cfg *config.Config, files []string) (*Keyring, error) {
+	if env := os.Getenv(IdentityFileEnv); env != "" {
+		files = append(files, filepath.SplitList(env)...)
+	}
+	files = append(files, cfg.IdentityFiles...)
+
+	keys := &Keyring{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read identity file: %w", err)
+		}
+		for n, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			identity, err := parseIdentity(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", file, n+1, err)
+			}
+			keys.identities = append(keys.identities, identity)
+		}
+	}
+	return keys, nil
+}
+
+// backupPassphrase reads the passphrase from the environment, or prompts
+// for it, confirming when it is new.
+func backupPassphrase(confirm bool) ([]byte, error) {
+	if passphrase := os.Getenv(BackupPassphraseEnv); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	passphrase, err := utils.PromptSecret(reader, "Backup passphrase: ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	if passphrase == "" {
+		return nil, errors.New("backup passphrase cannot be empty")
+	}
+	if confirm {
+		again, err := utils.PromptSecret(reader, "Confirm backup passphrase: ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		if again != passphrase {
+			return nil, errors.New("passphrases do not match")
+		}
+	}
+	return []byte(passphrase), nil
+}
+
+func isEncrypted(r *bufio.Reader) bool {
+	magic, _ := r.Peek(len(encryptionMagic))
+	return string(magic) == encryptionMagic
+}
+
+// decrypt reads the header from r, unwraps the file key with the first
+// identity or passphrase that fits, and returns a reader of the plaintext.
+func (k *Keyring) decrypt(r *bufio.Reader, name string) (io.Reader, error) {
+	if _, err := r.Discard(len(encryptionMagic)); err != nil {
+		return nil, err
+	}
+
+	var stanzas [][]string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("%s has a truncated encryption header", name)
+		}
+		if line == headerEnd {
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "->" {
+			return nil, fmt.Errorf("%s has a malformed encryption header", name)
+		}
+		stanzas = append(stanzas, fields[1:])
+	}
+
+	fileKey, err := k.unwrap(stanzas)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt %s: %w", name, err)
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, fmt.Errorf("%s is truncated", name)
+	}
+	aead, err := payloadCipher(fileKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return &openReader{r: r, aead: aead, name: name, sealed: make([]byte, chunkSize+aead.Overhead())}, nil
+}
+
+func (k *Keyring) unwrap(stanzas [][]string) ([]byte, error) {
+	hasPassphrase := false
+	for _, stanza := range stanzas {
+		switch {
+		case stanza[0] == "X25519" && len(stanza) == 3:
+			ephemeralBytes, err1 := rawBase64.DecodeString(stanza[1])
+			wrapped, err2 := rawBase64.DecodeString(stanza[2])
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			ephemeral, err := ecdh.X25519().NewPublicKey(ephemeralBytes)
+			if err != nil {
+				continue
+			}
+			for _, identity := range k.identities {
+				shared, err := identity.ECDH(ephemeral)
+				if err != nil {
+					continue
+				}
+				aead, err := stanzaCipher(shared, append(ephemeralBytes, identity.PublicKey().Bytes()...), "X25519")
+				if err != nil {
+					return nil, err
+				}
+				if fileKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), wrapped, nil); err == nil {
+					return fileKey, nil
+				}
+			}
+		case stanza[0] == "passphrase":
+			hasPassphrase = true
+		}
+	}
+	if !hasPassphrase {
+		return nil, fmt.Errorf("none of the identities fit, pass the right one with --identity or %s", IdentityFileEnv)
+	}
+
+	if k.passphrase == nil {
+		passphrase, err := backupPassphrase(false)
+		if err != nil {
+			return nil, err
+		}
+		k.passphrase = passphrase
+	}
+	for _, stanza := range stanzas {
+		if stanza[0] != "passphrase" || len(stanza) != 4 {
+			continue
+		}
+		salt, err1 := rawBase64.DecodeString(stanza[1])
+		iterations, err2 := strconv.Atoi(stanza[2])
+		wrapped, err3 := rawBase64.DecodeString(stanza[3])
+		if err1 != nil || err2 != nil || err3 != nil || iterations < 1 {
+			continue
+		}
+		if iterations > maxPassphraseIterations {
+			return nil, fmt.Errorf("the passphrase stanza asks for %d iterations, more than the %d allowed", iterations, maxPassphraseIterations)
+		}
+		derived, err := pbkdf2.Key(sha256.New, string(k.passphrase), salt, iterations, 32)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := stanzaCipher(derived, salt, "passphrase")
+		if err != nil {
+			return nil, err
+		}
+		if fileKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), wrapped, nil); err == nil {
+			return fileKey, nil
+		}
+	}
+	return nil, errors.New("wrong passphrase")
+}
+
+// canDecrypt checks that keys can unwrap an encrypted file's key, without
+// reading its contents. Plain files always pass.
+func (k *Keyring) canDecrypt(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	if !isEncrypted(r) {
+		return nil
+	}
+	_, err = k.decrypt(r, path)
+	return err
+}
+
+type openReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	name    string
+	sealed  []byte
+	plain   []byte
+	counter uint64
+	done    bool
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	for len(o.plain) == 0 {
+		if o.done {
+			return 0, io.EOF
+		}
+		if err := o.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, o.plain)
+	o.plain = o.plain[n:]
+	return n, nil
+}
+
+func (o *openReader) next() error {
+	n, err := io.ReadFull(o.r, o.sealed)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return fmt.Errorf("%s is truncated", o.name)
+		}
+		return err
+	}
+	// a chunk is the last one when nothing follows it
+	last := err == io.ErrUnexpectedEOF
+	if !last {
+		if _, peekErr := o.r.Peek(1); peekErr == io.EOF {
+			last = true
+		}
+	}
+
+	plain, err := o.aead.Open(o.sealed[:0], chunkNonce(o.counter, last), o.sealed[:n], nil)
+	if err != nil {
+		return fmt.Errorf("%s is corrupt or truncated", o.name)
+	}
+	o.plain = plain
+	o.counter++
+	o.done = last
+	return nil
+}
+
+// Keygen creates a key pair for encrypting backups. The secret key goes to
+// file, or stdout without one, and the public key to stdout.
+func Keygen(args []string) error {
+	var file string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--") || file != "" {
+			return fmt.Errorf("unknown argument: %s", arg)
+		}
+		file = arg
+	}
+
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	recipient := formatRecipient(identity.PublicKey())
+	content := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n",
+		time.Now().Format(time.RFC3339), recipient, identityPrefix+rawBase64.EncodeToString(identity.Bytes()))
+
+	if file == "" {
+		fmt.Print(content)
+		return nil
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create identity file: %w", err)
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	utils.SuccessPrint("Wrote the secret key to %s. Keep it safe, backups can't be restored without it\n", file)
+	fmt.Println(recipient)
+	return nil
+}
@@ -0,0 +1,204 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func newIdentity(t *testing.T) *ecdh.PrivateKey {
+	t.Helper()
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity
+}
+
+func encryptBytes(t *testing.T, payload []byte, e *Encryption) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	w, err := encryptTo(&out, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func decryptBytes(data []byte, keys *Keyring) ([]byte, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	if !isEncrypted(r) {
+		return nil, fmt.Errorf("missing encryption header")
+	}
+	plain, err := keys.decrypt(r, "test")
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(plain)
+}
+
+// payloadStart is the offset of the first sealed chunk: the header, then the
+// payload nonce.
+func payloadStart(t *testing.T, data []byte) int {
+	t.Helper()
+	end := bytes.Index(data, []byte(headerEnd))
+	if end < 0 {
+		t.Fatal("no header end")
+	}
+	return end + len(headerEnd) + 16
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	identity := newIdentity(t)
+	recipient := formatRecipient(identity.PublicKey())
+
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"one byte short of a chunk", chunkSize - 1},
+		{"exactly one chunk", chunkSize},
+		{"two chunks", 2 * chunkSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := make([]byte, tt.size)
+			rand.Read(payload)
+
+			data := encryptBytes(t, payload, &Encryption{Recipients: []string{recipient}})
+			got, err := decryptBytes(data, &Keyring{identities: []*ecdh.PrivateKey{identity}})
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Fatalf("got %d bytes back, want the original %d", len(got), len(payload))
+			}
+		})
+	}
+}
+
+func TestEncryptPassphraseRoundTrip(t *testing.T) {
+	payload := []byte("SELECT 1;\n")
+	data := encryptBytes(t, payload, &Encryption{passphrase: []byte("correct horse")})
+
+	got, err := decryptBytes(data, &Keyring{passphrase: []byte("correct horse")})
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("got %q, want %q", got, payload)
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	identity := newIdentity(t)
+	keys := &Keyring{identities: []*ecdh.PrivateKey{identity}}
+	sealedChunk := chunkSize + 16
+
+	payload := make([]byte, 2*chunkSize+100)
+	rand.Read(payload)
+	data := encryptBytes(t, payload, &Encryption{Recipients: []string{formatRecipient(identity.PublicKey())}})
+	start := payloadStart(t, data)
+	if _, err := decryptBytes(data, keys); err != nil {
+		t.Fatalf("decrypting the untouched file: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(data []byte) []byte
+	}{
+		{"truncated after the first chunk", func(data []byte) []byte {
+			return data[:start+sealedChunk]
+		}},
+		{"truncated after the second chunk", func(data []byte) []byte {
+			return data[:start+2*sealedChunk]
+		}},
+		{"last chunk dropped mid-way", func(data []byte) []byte {
+			return data[:len(data)-10]
+		}},
+		{"first two chunks swapped", func(data []byte) []byte {
+			first := data[start : start+sealedChunk]
+			second := data[start+sealedChunk : start+2*sealedChunk]
+			swapped := append([]byte{}, data[:start]...)
+			swapped = append(swapped, second...)
+			swapped = append(swapped, first...)
+			return append(swapped, data[start+2*sealedChunk:]...)
+		}},
+		{"flipped payload byte", func(data []byte) []byte {
+			data[start+5] ^= 1
+			return data
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := tt.tamper(append([]byte{}, data...))
+			if _, err := decryptBytes(tampered, keys); err == nil {
+				t.Fatal("decrypt succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestDecryptWrongKeys(t *testing.T) {
+	identity := newIdentity(t)
+	other := newIdentity(t)
+	payload := []byte("SELECT 1;\n")
+
+	tests := []struct {
+		name       string
+		encryption *Encryption
+		keys       *Keyring
+		want       string
+	}{
+		{
+			"wrong identity",
+			&Encryption{Recipients: []string{formatRecipient(identity.PublicKey())}},
+			&Keyring{identities: []*ecdh.PrivateKey{other}},
+			"none of the identities fit",
+		},
+		{
+			"wrong passphrase",
+			&Encryption{passphrase: []byte("correct horse")},
+			&Keyring{passphrase: []byte("battery staple")},
+			"wrong passphrase",
+		},
+		{
+			"wrong identity and passphrase",
+			&Encryption{Recipients: []string{formatRecipient(identity.PublicKey())}, passphrase: []byte("correct horse")},
+			&Keyring{identities: []*ecdh.PrivateKey{other}, passphrase: []byte("battery staple")},
+			"wrong passphrase",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encryptBytes(t, payload, tt.encryption)
+			_, err := decryptBytes(data, tt.keys)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecryptCapsPassphraseIterations(t *testing.T) {
+	header := fmt.Sprintf("%s-> passphrase %s %d %s\n%s",
+		encryptionMagic, rawBase64.EncodeToString(make([]byte, 16)), 1<<40, rawBase64.EncodeToString(make([]byte, 32)), headerEnd)
+	data := append([]byte(header), make([]byte, 16+16)...)
+
+	_, err := decryptBytes(data, &Keyring{passphrase: []byte("anything")})
+	if err == nil || !strings.Contains(err.Error(), "iterations") {
+		t.Fatalf("got error %v, want the iteration count refused", err)
+	}
+}
@@ -6,24 +6,71 @@ import (
 	"os/exec"
 	"proman/config"
 	"proman/utils"
+	"strings"
 )
 
 func Exec(cfg *config.Config, args []string) error {
-	if len(args) != 2 {
-		return fmt.Errorf("exec command expects exactly two arguments: the project ID and the filename")
+	var positional, tags []string
+	overrideProtection := false
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == OverrideProtectionFlag:
+			overrideProtection = true
+		case args[i] == "--tag":
+			if i+1 < len(args) {
+				tags = append(tags, args[i+1])
+				i++
+			} else {
+				return fmt.Errorf("--tag flag requires a value")
+			}
+		case !strings.HasPrefix(args[i], "--"):
+			positional = append(positional, args[i])
+		default:
+			return fmt.Errorf("unknown flag: %s", args[i])
+		}
 	}
 
-	projectID := args[0]
-	filename := args[1]
+	if len(positional) < 1 || len(positional)+len(tags) < 2 {
+		return fmt.Errorf("exec command expects a project ID (or @group / --tag) followed by the filename")
+	}
+
+	selectors := positional[:len(positional)-1]
+	filename := positional[len(positional)-1]
 
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
 		return fmt.Errorf("file not found: %s", filename)
 	}
 
+	targets, err := cfg.ResolveTargets(selectors, tags)
+	if err != nil {
+		return err
+	}
+
+	// confirm every protected target before anything runs
+	for _, projectID := range targets {
+		params, _ := cfg.GetConnection(projectID)
+		if err := confirmWrite(projectID, params, overrideProtection); err != nil {
+			return err
+		}
+	}
+
+	if isSingleTarget(selectors, tags) {
+		return execFile(cfg, targets[0], filename)
+	}
+	return forEachTarget(targets, func(projectID string) error {
+		return execFile(cfg, projectID, filename)
+	})
+}
+
+func execFile(cfg *config.Config, projectID, filename string) error {
 	params, found := cfg.GetConnection(projectID)
 	if !found {
 		return fmt.Errorf("project with ID '%s' not found", projectID)
 	}
+	params, err := PrepareConnection(cfg, projectID, params, OpExec)
+	if err != nil {
+		return err
+	}
 
 	binaries := cfg.GetBinaryPaths()
 	if binaries.PSQL == "" {
@@ -35,11 +82,11 @@ func Exec(cfg *config.Config, args []string) error {
 	defer spin.Stop()
 
 	cmd := exec.Command(binaries.PSQL, "-h", params.Host, "-p", params.Port, "-U", params.User, "-d", params.DBName, "-f", filename)
-	cmd.Env = append(os.Environ(), "PGPASSWORD="+params.Password)
+	cmd.Env = connectionEnv(params)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	err := cmd.Run()
+	err = cmd.Run()
 	if err != nil {
 		return fmt.Errorf("failed to execute psql: %w", err)
 	}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"proman/utils"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// forEachTarget runs fn against each connection in turn and prints a
+// per-connection summary table, unless structured output was asked for. One
+// failing connection doesn't stop the rest, but the returned error reports how
+// many failed.
+func forEachTarget(targets []string, fn func(projectID string) error) error {
+	errs := make([]error, len(targets))
+	durations := make([]time.Duration, len(targets))
+
+	for i, projectID := range targets {
+		if interrupted.Load() {
+			errs[i] = errInterrupted
+			continue
+		}
+		utils.InfoPrint("\n--- %s ---\n", projectID)
+		start := time.Now()
+		errs[i] = fn(projectID)
+		durations[i] = time.Since(start)
+		if errs[i] != nil {
+			utils.ErrorPrint("%s: %v\n", projectID, errs[i])
+		}
+	}
+
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if utils.MachineOutput() {
+		if failed > 0 {
+			return fmt.Errorf("%d of %d project(s) failed", failed, len(targets))
+		}
+		return nil
+	}
+
+	fmt.Println()
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(w, "ID\tSTATUS\tDURATION\tERROR")
+	fmt.Fprintln(w, "--\t------\t--------\t-----")
+
+	for i, projectID := range targets {
+		status, errMessage := "ok", ""
+		if errs[i] != nil {
+			status, errMessage = "failed", errs[i].Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", projectID, status, durations[i].Round(time.Millisecond), errMessage)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d project(s) failed", failed, len(targets))
+	}
+	return nil
+}
+
+// isSingleTarget reports whether the selectors name exactly one project
+// directly, in which case commands keep their plain single-project output.
+func isSingleTarget(selectors, tags []string) bool {
+	return len(selectors) == 1 && len(tags) == 0 && !strings.HasPrefix(selectors[0], "@")
+}
@@ -0,0 +1,327 @@
+package database
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"proman/config"
+	"proman/utils"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Formats for the schema and data parts of a backup. Roles are always plain
+// SQL, since pg_dumpall has no archive format.
+const (
+	FormatPlain     = "plain"
+	FormatCustom    = "custom"
+	FormatDirectory = "directory"
+)
+
+func ParseFormat(format string) (string, error) {
+	switch format {
+	case "", FormatPlain:
+		return FormatPlain, nil
+	case FormatCustom, FormatDirectory:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown format '%s', expected plain, custom or directory", format)
+	}
+}
+
+// defaultJobs is the number of parallel workers when neither the command nor
+// the connection sets one.
+func defaultJobs(params config.ConnectionParams) int {
+	if params.Jobs > 0 {
+		return params.Jobs
+	}
+	return runtime.NumCPU()
+}
+
+// archiveArgs are the pg_dump flags for an archive format. Archives are
+// compressed by pg_dump itself, so the compression setting is passed on
+// rather than applied to the output stream. pg_dump compresses archives
+// unless told not to, so no compression becomes -Z 0.
+func archiveArgs(encoding dumpEncoding) []string {
+	var args []string
+	switch encoding.format {
+	case FormatCustom:
+		args = append(args, "-Fc")
+	case FormatDirectory:
+		args = append(args, "-Fd", "-j", strconv.Itoa(encoding.jobs))
+	default:
+		return nil
+	}
+
+	switch encoding.compression.Method {
+	case CompressGzip:
+		level := encoding.compression.Level
+		if level == 0 {
+			level = 6
+		}
+		args = append(args, "-Z", strconv.Itoa(level))
+	case CompressZstd:
+		method := "zstd"
+		if encoding.compression.Level != 0 {
+			method += ":" + strconv.Itoa(encoding.compression.Level)
+		}
+		args = append(args, "--compress="+method)
+	default:
+		args = append(args, "-Z", "0")
+	}
+	return args
+}
+
+// checkArchiveCompression refuses zstd archives when pg_dump is older than 16,
+// the first version that writes them.
+func checkArchiveCompression(binaries config.BinaryPaths, encoding dumpEncoding) error {
+	if encoding.format == FormatPlain || encoding.compression.Method != CompressZstd {
+		return nil
+	}
+	version, err := utils.BinaryVersion("pg_dump", binaries.PGDump)
+	if err != nil {
+		return err
+	}
+	if major, _ := strconv.Atoi(strings.Split(version, ".")[0]); major < 16 {
+		return fmt.Errorf(
+			"pg_dump %s can't compress %s archives with zstd, which needs pg_dump 16 or newer. Use gzip or the plain format",
+			version, encoding.format,
+		)
+	}
+	return nil
+}
+
+// dumpArchive runs a custom format dump into filename. pg_dump writes the file
+// itself rather than a pipe, so it can go back and record where each table's
+// data starts, which parallel restores rely on. An empty filename dumps into
+// a temporary file instead.
+func dumpArchive(cmd *exec.Cmd, filename, tempPattern, what string) (string, error) {
+	isTempFile := filename == ""
+	if isTempFile {
+		outFile, err := os.CreateTemp("", tempPattern+".dump")
+		if err != nil {
+			return "", fmt.Errorf("failed to create temporary output file: %w", err)
+		}
+		outFile.Close()
+		filename = outFile.Name()
+	}
+
+	if err := runDump(cmd, filename, what); err != nil {
+		if isTempFile {
+			os.Remove(filename)
+		}
+		return "", err
+	}
+	return filename, nil
+}
+
+// dumpDirectory runs a directory format dump into dir, which pg_dump creates.
+// An empty dir dumps into a temporary directory instead.
+func dumpDirectory(cmd *exec.Cmd, dir, tempPattern, what string) (string, error) {
+	isTempDir := dir == ""
+	if isTempDir {
+		parent, err := os.MkdirTemp("", tempPattern)
+		if err != nil {
+			return "", fmt.Errorf("failed to create temporary output directory: %w", err)
+		}
+		dir = filepath.Join(parent, what)
+	}
+
+	if err := runDump(cmd, dir, what); err != nil {
+		if isTempDir {
+			os.RemoveAll(filepath.Dir(dir))
+		} else {
+			os.RemoveAll(dir)
+		}
+		return "", err
+	}
+	return dir, nil
+}
+
+// runDump runs pg_dump with its output going to path.
+func runDump(cmd *exec.Cmd, path, what string) error {
+	spin := utils.NewSpinner("Dumping %s to %s", what, path)
+	spin.Start()
+	defer spin.Stop()
+
+	cmd.Args = append(cmd.Args, "-f", path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// checksumPath hashes a file, or for a directory format dump, the sorted list
+// of its files with their own hashes.
+func checksumPath(path string) (int64, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, "", err
+	}
+	if !info.IsDir() {
+		return checksumFile(path)
+	}
+
+	var files []string
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, p)
+		}
+		return err
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	sort.Strings(files)
+
+	var total int64
+	tree := sha256.New()
+	for _, file := range files {
+		size, sum, err := checksumFile(file)
+		if err != nil {
+			return 0, "", err
+		}
+		rel, _ := filepath.Rel(path, file)
+		fmt.Fprintf(tree, "%s  %s\n", sum, filepath.ToSlash(rel))
+		total += size
+	}
+	return total, hex.EncodeToString(tree.Sum(nil)), nil
+}
+
+func checksumFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to checksum %s: %w", path, err)
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// restoreArchive loads a custom or directory format part with pg_restore.
+// Unencrypted archives load with jobs parallel workers; an encrypted one is
+// decrypted on the fly into pg_restore's stdin, which only a single worker
+// can read, so it loads in one transaction instead.
+func restoreArchive(params config.ConnectionParams, binaries config.BinaryPaths, keys *Keyring, message, path string, jobs int, env ...string) error {
+	args := []string{
+		"-h", params.Host, "-p", params.Port, "-U", params.User, "-d", params.DBName,
+		"--no-owner", "--no-privileges", "--exit-on-error",
+	}
+
+	plain, err := isPlainDump(path)
+	if err != nil {
+		return err
+	}
+	var input io.ReadCloser
+	switch {
+	case !plain:
+		input, err = openDump(path, binaries, keys)
+		if err != nil {
+			return err
+		}
+		args = append(args, "--single-transaction")
+	case jobs > 1:
+		args = append(args, "-j", strconv.Itoa(jobs), path)
+	default:
+		args = append(args, "--single-transaction", path)
+	}
+
+	spin := utils.NewSpinner("%s", message)
+	spin.Start()
+	defer spin.Stop()
+
+	cmd := exec.Command(PGRestorePath(binaries), args...)
+	cmd.Env = append(connectionEnv(params), env...)
+	if input != nil {
+		cmd.Stdin = input
+	}
+	cmd.Stdout = io.Discard
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if input != nil {
+		if closeErr := input.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// archiveToSQL turns a custom or directory format part into a plain SQL file
+// in a temporary location.
+func archiveToSQL(path, pattern string, binaries config.BinaryPaths, keys *Keyring) (string, error) {
+	out, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary output file: %w", err)
+	}
+	out.Close()
+
+	args := []string{"-f", out.Name()}
+	plain, err := isPlainDump(path)
+	if err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	var input io.ReadCloser
+	if plain {
+		args = append(args, path)
+	} else {
+		input, err = openDump(path, binaries, keys)
+		if err != nil {
+			os.Remove(out.Name())
+			return "", err
+		}
+	}
+
+	cmd := exec.Command(PGRestorePath(binaries), args...)
+	if input != nil {
+		cmd.Stdin = input
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if input != nil {
+		if closeErr := input.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("failed to read archive %s: %w: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return out.Name(), nil
+}
+
+// listArchive has pg_restore read an archive's table of contents, which
+// catches archives it can't read.
+func listArchive(path string, binaries config.BinaryPaths) error {
+	cmd := exec.Command(PGRestorePath(binaries), "-l", path)
+	cmd.Stdout = io.Discard
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pg_restore can't read %s: %w: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"proman/config"
+	"strings"
+	"time"
+)
+
+// supabaseExtensions are the extensions reported by CheckConnection. Stock
+// Postgres extensions such as plpgsql are left out to keep the report short.
+var supabaseExtensions = []string{
+	"pg_graphql", "pg_net", "pgsodium", "supabase_vault", "pg_cron", "pgjwt", "pg_stat_statements",
+	"pgcrypto", "uuid-ossp", "pg_jsonschema", "wrappers", "vector", "postgis", "pgaudit", "plv8",
+	"pg_hashids", "pgroonga", "timescaledb", "http", "pg_repack", "index_advisor", "hypopg",
+}
+
+type ConnectionStatus struct {
+	Reachable  bool
+	Authorized bool
+	Latency    time.Duration
+	Version    string
+	TLS        bool
+	Extensions []string
+	Err        error
+}
+
+func (s ConnectionStatus) OK() bool {
+	return s.Reachable && s.Authorized && s.Err == nil
+}
+
+// CheckConnection probes a connection: a TCP dial measures reachability and
+// round-trip latency, then psql logs in and fingerprints the server.
+func CheckConnection(params config.ConnectionParams, binaries config.BinaryPaths) ConnectionStatus {
+	status := ConnectionStatus{}
+
+	host := params.Host
+	if params.HostAddr != "" {
+		host = params.HostAddr
+	}
+	address := net.JoinHostPort(host, params.Port)
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		status.Err = fmt.Errorf("could not reach %s: %w", address, err)
+		return status
+	}
+	status.Latency = time.Since(start)
+	status.Reachable = true
+	conn.Close()
+
+	if binaries.PSQL == "" {
+		status.Err = fmt.Errorf("path to psql binary is not set in the config. Please run 'proman init'")
+		return status
+	}
+
+	quoted := make([]string, len(supabaseExtensions))
+	for i, ext := range supabaseExtensions {
+		quoted[i] = "'" + ext + "'"
+	}
+	query := fmt.Sprintf(
+		"SELECT current_setting('server_version'), "+
+			"coalesce((SELECT ssl FROM pg_stat_ssl WHERE pid = pg_backend_pid()), false), "+
+			"coalesce((SELECT string_agg(extname, ',' ORDER BY extname) FROM pg_extension WHERE extname IN (%s)), '')",
+		strings.Join(quoted, ", "),
+	)
+
+	cmd := exec.Command(
+		binaries.PSQL, "-X", "-A", "-t", "-F", "|",
+		"-h", params.Host, "-p", params.Port, "-U", params.User, "-d", params.DBName,
+		"-c", query,
+	)
+	cmd.Env = append(connectionEnv(params), "PGCONNECT_TIMEOUT=5")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		status.Err = fmt.Errorf("login failed: %s", strings.TrimSpace(stderr.String()))
+		return status
+	}
+	status.Authorized = true
+
+	fields := strings.Split(strings.TrimSpace(string(out)), "|")
+	if len(fields) != 3 {
+		status.Err = fmt.Errorf("unexpected response from server: %q", strings.TrimSpace(string(out)))
+		return status
+	}
+	status.Version = fields[0]
+	status.TLS = fields[1] == "t"
+	if fields[2] != "" {
+		status.Extensions = strings.Split(fields[2], ",")
+	}
+
+	return status
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"proman/config"
+	"sort"
+	"time"
+)
+
+// ManifestFileName is the file in every backup set describing its contents.
+const ManifestFileName = "manifest.json"
+
+// Manifest records what a backup set holds and how it was taken, so restores,
+// pruning and verification don't have to guess from file names. Snapshot is
+// the exported snapshot the schema and data were dumped from, and SnapshotLSN
+// the WAL position it was taken at. Encryption lists who can decrypt the parts.
+type Manifest struct {
+	ProjectID       string              `json:"project_id"`
+	Host            string              `json:"host"`
+	Database        string              `json:"database"`
+	ServerVersion   string              `json:"server_version"`
+	PGDumpVersion   string              `json:"pg_dump_version"`
+	Official        bool                `json:"official,omitempty"`
+	Snapshot        string              `json:"snapshot,omitempty"`
+	SnapshotLSN     string              `json:"snapshot_lsn,omitempty"`
+	Status          string              `json:"status"`
+	Error           string              `json:"error,omitempty"`
+	Parts           []ManifestPart      `json:"parts"`
+	Encryption      *ManifestEncryption `json:"encryption,omitempty"`
+	ExcludedSchemas []string            `json:"excluded_schemas"`
+	StartedAt       time.Time           `json:"started_at"`
+	FinishedAt      time.Time           `json:"finished_at"`
+	PromanVersion   string              `json:"proman_version"`
+}
+
+type ManifestPart struct {
+	Name      string `json:"name"`
+	File      string `json:"file"`
+	SizeBytes int64  `json:"size_bytes"`
+	SHA256    string `json:"sha256"`
+	// Format is custom or directory for pg_dump archives, which pg_restore
+	// loads. A directory part's checksum covers every file in it
+	Format string `json:"format,omitempty"`
+	// Compression is gzip or zstd when the file is compressed
+	Compression string `json:"compression,omitempty"`
+	Encrypted   bool   `json:"encrypted,omitempty"`
+}
+
+type ManifestEncryption struct {
+	Recipients []string `json:"recipients"`
+	Passphrase bool     `json:"passphrase"`
+}
+
+// Manifest statuses. A set is only safe to restore from when it is complete.
+const (
+	ManifestComplete = "complete"
+	ManifestFailed   = "failed"
+)
+
+// addPart checksums a file of the set and lists it in the manifest.
+func (m *Manifest) addPart(name, path string, encoding dumpEncoding) error {
+	size, sum, err := checksumPath(path)
+	if err != nil {
+		return err
+	}
+
+	part := ManifestPart{
+		Name:        name,
+		File:        filepath.Base(path),
+		SizeBytes:   size,
+		SHA256:      sum,
+		Compression: encoding.compression.Method,
+		Encrypted:   encoding.encryption.enabled(),
+	}
+	if encoding.format != FormatPlain {
+		part.Format = encoding.format
+	}
+	m.Parts = append(m.Parts, part)
+	return nil
+}
+
+// Part returns the named part of the set, if it was included.
+func (m *Manifest) Part(name string) (ManifestPart, bool) {
+	for _, part := range m.Parts {
+		if part.Name == name {
+			return part, true
+		}
+	}
+	return ManifestPart{}, false
+}
+
+// verifyPart checks a part's file in dir against the checksum in the manifest.
+func verifyPart(dir string, part ManifestPart) error {
+	_, sum, err := checksumPath(filepath.Join(dir, part.File))
+	if err != nil {
+		return err
+	}
+	if sum != part.SHA256 {
+		return fmt.Errorf("%s does not match its checksum in the manifest", part.File)
+	}
+	return nil
+}
+
+func writeManifest(dir string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return config.WriteFileAtomic(filepath.Join(dir, ManifestFileName), data)
+}
+
+// ReadManifest loads the manifest of the backup set in dir.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFileName))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse the manifest in %s: %w", dir, err)
+	}
+	return &m, nil
+}
+
+type backupSet struct {
+	dir      string
+	manifest *Manifest
+}
+
+// listBackupSets returns a project's backup sets under root, newest first.
+// Directories without a readable manifest are skipped.
+func listBackupSets(root, projectID string) ([]backupSet, error) {
+	entries, err := os.ReadDir(filepath.Join(root, projectID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var sets []backupSet
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, projectID, entry.Name())
+		m, err := ReadManifest(dir)
+		if err != nil {
+			continue
+		}
+		sets = append(sets, backupSet{dir, m})
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].manifest.StartedAt.After(sets[j].manifest.StartedAt) })
+	return sets, nil
+}
+
+// findBackupSet resolves a backup reference: a set directory, a path relative
+// to the backup root like <project>/<set>, or a project ID for its newest
+// complete set.
+func findBackupSet(root, ref string) (backupSet, error) {
+	for _, dir := range []string{ref, filepath.Join(root, ref)} {
+		if m, err := ReadManifest(dir); err == nil {
+			return backupSet{dir, m}, nil
+		}
+	}
+
+	sets, err := listBackupSets(root, ref)
+	if err != nil {
+		return backupSet{}, err
+	}
+	for _, set := range sets {
+		if set.manifest.Status == ManifestComplete {
+			return set, nil
+		}
+	}
+	return backupSet{}, fmt.Errorf("no backup set found for '%s'", ref)
+}
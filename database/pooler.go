@@ -0,0 +1,114 @@
+package database
+
+import (
+	"fmt"
+	"proman/config"
+	"proman/utils"
+	"strings"
+)
+
+// Operation is the kind of work a connection is prepared for. It decides which
+// of a project's endpoints is used.
+type Operation int
+
+const (
+	// OpDump covers pg_dump, pg_dumpall and supabase db dump.
+	OpDump Operation = iota
+	// OpMigration covers generating and applying schema migrations.
+	OpMigration
+	// OpExec covers running SQL files with psql.
+	OpExec
+	// OpProbe covers short health check queries.
+	OpProbe
+	// OpRestore covers loading a backup set with psql.
+	OpRestore
+)
+
+func (op Operation) String() string {
+	switch op {
+	case OpDump:
+		return "dumps"
+	case OpMigration:
+		return "migrations"
+	case OpExec:
+		return "db exec"
+	case OpRestore:
+		return "restores"
+	default:
+		return "health checks"
+	}
+}
+
+type endpoint struct {
+	name     string
+	endpoint config.Endpoint
+}
+
+// usesTransactionPooler reports whether op may run through Supavisor's
+// transaction mode. pg_dump, restores and migration DDL rely on session state
+// that transaction mode doesn't keep, so they never do.
+func (op Operation) usesTransactionPooler() bool {
+	return op == OpExec || op == OpProbe
+}
+
+// selectEndpoint points params at the endpoint best suited to op. The direct
+// connection is preferred, then Supavisor's session mode. Transaction mode is
+// only a last resort for db exec and health checks, and is refused for
+// everything else.
+func selectEndpoint(projectID string, params config.ConnectionParams, op Operation) (config.ConnectionParams, error) {
+	hasTransactionPooler := params.TransactionPooler != nil && params.TransactionPooler.Host != ""
+
+	var candidates []endpoint
+	if params.Host != "" {
+		candidates = append(candidates, endpoint{"direct", config.Endpoint{Host: params.Host, Port: params.Port, User: params.User}})
+	}
+	if params.SessionPooler != nil && params.SessionPooler.Host != "" {
+		candidates = append(candidates, endpoint{"session pooler", *params.SessionPooler})
+	}
+	if hasTransactionPooler && op.usesTransactionPooler() {
+		candidates = append(candidates, endpoint{"transaction pooler", *params.TransactionPooler})
+	}
+
+	if len(candidates) == 0 {
+		if hasTransactionPooler {
+			return params, fmt.Errorf(
+				"project '%s' only has a transaction pooler configured, which can't be used for %s. "+
+					"Register a direct host or session pooler",
+				projectID, op,
+			)
+		}
+		return params, fmt.Errorf("project '%s' has no host or pooler endpoint configured", projectID)
+	}
+
+	chosen := candidates[0]
+	if chosen.name == "transaction pooler" && op == OpExec {
+		utils.WarningPrint(
+			"Project '%s' only has a transaction pooler configured. Statements in the file that rely on "+
+				"session state, such as SET or temporary tables, can fail there\n",
+			projectID,
+		)
+	}
+
+	if chosen.name != "direct" {
+		params.Host = chosen.endpoint.Host
+		params.Port = chosen.endpoint.Port
+		params.User = PoolerUser(params, chosen.endpoint)
+	}
+	if params.Port == "" {
+		params.Port = "5432"
+	}
+	return params, nil
+}
+
+// PoolerUser returns the user for a Supavisor endpoint. Supavisor identifies
+// the project through the user name, so postgres becomes postgres.<ref> unless
+// the endpoint sets its own user.
+func PoolerUser(params config.ConnectionParams, pooler config.Endpoint) string {
+	if pooler.User != "" {
+		return pooler.User
+	}
+	if params.SupabaseProjectID != "" && !strings.Contains(params.User, ".") {
+		return params.User + "." + params.SupabaseProjectID
+	}
+	return params.User
+}
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"proman/config"
+	"proman/utils"
+
+	"golang.org/x/term"
+)
+
+// OverrideProtectionFlag lets scripts write to protected connections without
+// the typed confirmation.
+const OverrideProtectionFlag = "--i-know-this-is-prod"
+
+// confirmWrite guards a write against a protected connection: the user has to
+// type the project ID, or pass OverrideProtectionFlag when there's no terminal.
+func confirmWrite(projectID string, params config.ConnectionParams, override bool) error {
+	if !params.Protected {
+		return nil
+	}
+	return ConfirmProtected(projectID, "This operation will write to it", override)
+}
+
+// canConfirmWrite fails early when a write to a protected connection could
+// never be confirmed, so slow preparation isn't wasted on it.
+func canConfirmWrite(projectID string, params config.ConnectionParams, override bool) error {
+	if !params.Protected || override || term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+	return refuseWithoutTerminal(projectID)
+}
+
+// ConfirmProtected asks for the typed confirmation of something done to a
+// protected connection, described by action.
+func ConfirmProtected(projectID, action string, override bool) error {
+	if override {
+		utils.WarningPrint("Project '%s' is protected, continuing because %s was given\n", projectID, OverrideProtectionFlag)
+		return nil
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return refuseWithoutTerminal(projectID)
+	}
+
+	utils.WarningPrint("Project '%s' is protected. %s\n", projectID, action)
+	reader := bufio.NewReader(os.Stdin)
+	response, err := utils.Prompt(reader, fmt.Sprintf("Type the project ID '%s' to continue: ", projectID))
+	if err != nil {
+		return fmt.Errorf("failed to read user input: %w", err)
+	}
+	if response != projectID {
+		return fmt.Errorf("confirmation did not match '%s', aborting", projectID)
+	}
+	return nil
+}
+
+func refuseWithoutTerminal(projectID string) error {
+	return fmt.Errorf(
+		"project '%s' is protected; refusing to write to it without a terminal unless %s is given",
+		projectID, OverrideProtectionFlag,
+	)
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"proman/config"
+	"proman/utils"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// PruneEntry is one backup set considered by prune.
+type PruneEntry struct {
+	Project   string    `json:"project"`
+	Set       string    `json:"set"`
+	Path      string    `json:"path"`
+	StartedAt time.Time `json:"started_at"`
+	Status    string    `json:"status"`
+	SizeBytes int64     `json:"size_bytes"`
+	Action    string    `json:"action"`
+	Reason    string    `json:"reason"`
+}
+
+func Prune(cfg *config.Config, args []string) error {
+	var selectors, tags []string
+	pruneAll, dryRun := false, false
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--all":
+			pruneAll = true
+		case arg == "--dry-run":
+			dryRun = true
+		case arg == "--tag":
+			if i+1 < len(args) {
+				tags = append(tags, args[i+1])
+				i++
+			} else {
+				return fmt.Errorf("--tag flag requires a value")
+			}
+		case !strings.HasPrefix(arg, "--"):
+			selectors = append(selectors, arg)
+		default:
+			return fmt.Errorf("unknown flag: %s", arg)
+		}
+	}
+
+	var projectIDs []string
+	if pruneAll {
+		projectIDs = cfg.ListConnections()
+		sort.Strings(projectIDs)
+	} else {
+		var err error
+		projectIDs, err = cfg.ResolveTargets(selectors, tags)
+		if err != nil {
+			return err
+		}
+	}
+	if len(projectIDs) == 0 {
+		return fmt.Errorf("prune command requires a project ID, @group, --tag or --all")
+	}
+
+	entries := []PruneEntry{}
+	for _, projectID := range projectIDs {
+		params, found := cfg.GetConnection(projectID)
+		if !found {
+			return fmt.Errorf("project with ID '%s' not found", projectID)
+		}
+		policy, found := cfg.GetRetention(params)
+		if !found {
+			if !pruneAll {
+				utils.WarningPrint("Project '%s' has no retention policy, keeping all of its backups\n", projectID)
+			}
+			continue
+		}
+
+		sets, err := listBackupSets(cfg.GetBackupRoot(), projectID)
+		if err != nil {
+			return err
+		}
+		for i, reason := range retain(sets, policy) {
+			entry := PruneEntry{
+				Project:   projectID,
+				Set:       filepath.Base(sets[i].dir),
+				Path:      sets[i].dir,
+				StartedAt: sets[i].manifest.StartedAt,
+				Status:    sets[i].manifest.Status,
+				SizeBytes: dirSize(sets[i].dir),
+				Action:    "keep",
+				Reason:    reason,
+			}
+			if reason == "" {
+				entry.Action = "delete"
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	var reclaimed int64
+	deleted, failed := 0, 0
+	for i, entry := range entries {
+		if entry.Action != "delete" {
+			continue
+		}
+		if dryRun {
+			entries[i].Action = "would delete"
+		} else if err := os.RemoveAll(entry.Path); err != nil {
+			entries[i].Action = "failed"
+			entries[i].Reason = err.Error()
+			failed++
+			continue
+		}
+		reclaimed += entry.SizeBytes
+		deleted++
+	}
+
+	if utils.MachineOutput() {
+		return printResults(pruneError(failed), entries)
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(w, "ID\tSET\tSTATUS\tSIZE\tACTION\tREASON")
+	fmt.Fprintln(w, "--\t---\t------\t----\t------\t------")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			entry.Project, entry.Set, entry.Status, utils.FormatBytes(entry.SizeBytes), entry.Action, entry.Reason)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if dryRun {
+		utils.InfoPrint("\nWould delete %d backup set(s) and reclaim %s\n", deleted, utils.FormatBytes(reclaimed))
+	} else {
+		utils.SuccessPrint("\nDeleted %d backup set(s) and reclaimed %s\n", deleted, utils.FormatBytes(reclaimed))
+	}
+	return pruneError(failed)
+}
+
+func pruneError(failed int) error {
+	if failed > 0 {
+		return fmt.Errorf("%d backup set(s) could not be deleted", failed)
+	}
+	return nil
+}
+
+// retain applies a retention policy to sets, which are ordered newest first,
+// and returns why each set is kept, or "" for the sets to delete. Only
+// complete sets count towards the rules. Daily, weekly and monthly tiers keep
+// the newest set of each of the most recent days, weeks and months. The
+// newest complete full backup is always kept.
+func retain(sets []backupSet, policy config.RetentionPolicy) []string {
+	reasons := make([]string, len(sets))
+	keep := func(i int, reason string) {
+		if reasons[i] == "" {
+			reasons[i] = reason
+		} else {
+			reasons[i] += ", " + reason
+		}
+	}
+
+	last := 0
+	seen := map[string]map[string]bool{"daily": {}, "weekly": {}, "monthly": {}}
+	limits := map[string]int{"daily": policy.KeepDaily, "weekly": policy.KeepWeekly, "monthly": policy.KeepMonthly}
+	newestFull := -1
+
+	for i, set := range sets {
+		m := set.manifest
+		if m.Status != ManifestComplete {
+			continue
+		}
+		if newestFull < 0 && isFullBackup(m) {
+			newestFull = i
+		}
+		if last < policy.KeepLast {
+			last++
+			keep(i, "last")
+		}
+
+		started := m.StartedAt.Local()
+		year, week := started.ISOWeek()
+		periods := map[string]string{
+			"daily":   started.Format("2006-01-02"),
+			"weekly":  fmt.Sprintf("%d-W%02d", year, week),
+			"monthly": started.Format("2006-01"),
+		}
+		for _, tier := range []string{"daily", "weekly", "monthly"} {
+			period := periods[tier]
+			if seen[tier][period] || len(seen[tier]) >= limits[tier] {
+				continue
+			}
+			seen[tier][period] = true
+			keep(i, tier)
+		}
+	}
+
+	if newestFull >= 0 && reasons[newestFull] == "" {
+		keep(newestFull, "newest full backup")
+	}
+	return reasons
+}
+
+func isFullBackup(m *Manifest) bool {
+	for _, name := range []string{"roles", "schema", "data"} {
+		if _, found := m.Part(name); !found {
+			return false
+		}
+	}
+	return true
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
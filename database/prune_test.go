@@ -0,0 +1,141 @@
+package database
+
+import (
+	"proman/config"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testSet is a backup set started at a "2006-01-02 15:04" local time.
+func testSet(t *testing.T, started, status string, parts ...string) backupSet {
+	t.Helper()
+	at, err := time.ParseInLocation("2006-01-02 15:04", started, time.Local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) == 0 {
+		parts = []string{"roles", "schema", "data"}
+	}
+	m := &Manifest{Status: status, StartedAt: at}
+	for _, part := range parts {
+		m.Parts = append(m.Parts, ManifestPart{Name: part})
+	}
+	return backupSet{dir: started, manifest: m}
+}
+
+func TestRetain(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy config.RetentionPolicy
+		sets   []backupSet
+		want   []string
+	}{
+		{
+			name:   "keep last",
+			policy: config.RetentionPolicy{KeepLast: 2},
+			sets: []backupSet{
+				testSet(t, "2024-03-10 12:00", ManifestComplete),
+				testSet(t, "2024-03-09 12:00", ManifestComplete),
+				testSet(t, "2024-03-08 12:00", ManifestComplete),
+				testSet(t, "2024-03-07 12:00", ManifestComplete),
+			},
+			want: []string{"last", "last", "", ""},
+		},
+		{
+			name:   "daily keeps the newest set of each day",
+			policy: config.RetentionPolicy{KeepDaily: 2},
+			sets: []backupSet{
+				testSet(t, "2024-03-10 18:00", ManifestComplete),
+				testSet(t, "2024-03-10 09:00", ManifestComplete),
+				testSet(t, "2024-03-09 12:00", ManifestComplete),
+				testSet(t, "2024-03-08 12:00", ManifestComplete),
+			},
+			want: []string{"daily", "", "daily", ""},
+		},
+		{
+			name:   "weekly follows ISO weeks across the new year",
+			policy: config.RetentionPolicy{KeepWeekly: 2},
+			sets: []backupSet{
+				testSet(t, "2025-01-01 12:00", ManifestComplete), // 2025-W01
+				testSet(t, "2024-12-30 12:00", ManifestComplete), // 2025-W01
+				testSet(t, "2024-12-29 12:00", ManifestComplete), // 2024-W52
+				testSet(t, "2024-12-23 12:00", ManifestComplete), // 2024-W52
+				testSet(t, "2024-12-22 12:00", ManifestComplete), // 2024-W51
+			},
+			want: []string{"weekly", "", "weekly", "", ""},
+		},
+		{
+			name:   "monthly across the new year",
+			policy: config.RetentionPolicy{KeepMonthly: 2},
+			sets: []backupSet{
+				testSet(t, "2025-01-05 12:00", ManifestComplete),
+				testSet(t, "2024-12-31 12:00", ManifestComplete),
+				testSet(t, "2024-12-01 12:00", ManifestComplete),
+				testSet(t, "2024-11-15 12:00", ManifestComplete),
+			},
+			want: []string{"monthly", "monthly", "", ""},
+		},
+		{
+			name:   "rules combine",
+			policy: config.RetentionPolicy{KeepLast: 1, KeepDaily: 2, KeepMonthly: 2},
+			sets: []backupSet{
+				testSet(t, "2024-03-10 18:00", ManifestComplete),
+				testSet(t, "2024-03-10 09:00", ManifestComplete),
+				testSet(t, "2024-03-09 12:00", ManifestComplete),
+				testSet(t, "2024-02-20 12:00", ManifestComplete),
+				testSet(t, "2024-02-10 12:00", ManifestComplete),
+			},
+			want: []string{"last, daily, monthly", "", "daily", "monthly", ""},
+		},
+		{
+			name:   "failed sets count towards no rule",
+			policy: config.RetentionPolicy{KeepLast: 1, KeepDaily: 1},
+			sets: []backupSet{
+				testSet(t, "2024-03-11 12:00", ManifestFailed),
+				testSet(t, "2024-03-10 12:00", ManifestComplete),
+				testSet(t, "2024-03-10 08:00", ManifestFailed),
+				testSet(t, "2024-03-09 12:00", ManifestComplete),
+			},
+			want: []string{"", "last, daily", "", ""},
+		},
+		{
+			name:   "newest full backup is kept when no rule keeps it",
+			policy: config.RetentionPolicy{KeepLast: 2},
+			sets: []backupSet{
+				testSet(t, "2024-03-10 12:00", ManifestComplete, "schema"),
+				testSet(t, "2024-03-09 12:00", ManifestComplete, "data"),
+				testSet(t, "2024-03-08 12:00", ManifestComplete),
+				testSet(t, "2024-03-07 12:00", ManifestComplete),
+			},
+			want: []string{"last", "last", "newest full backup", ""},
+		},
+		{
+			name:   "a failed full backup isn't the newest full backup",
+			policy: config.RetentionPolicy{KeepLast: 1},
+			sets: []backupSet{
+				testSet(t, "2024-03-10 12:00", ManifestComplete, "schema"),
+				testSet(t, "2024-03-09 12:00", ManifestFailed),
+				testSet(t, "2024-03-08 12:00", ManifestComplete),
+			},
+			want: []string{"last", "", "newest full backup"},
+		},
+		{
+			name:   "newest full backup kept by a rule gets no extra reason",
+			policy: config.RetentionPolicy{KeepLast: 1},
+			sets: []backupSet{
+				testSet(t, "2024-03-10 12:00", ManifestComplete),
+				testSet(t, "2024-03-09 12:00", ManifestComplete),
+			},
+			want: []string{"last", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := retain(tt.sets, tt.policy)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("retain() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,423 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"proman/config"
+	"proman/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type restoreOptions struct {
+	schemaOnly, dataOnly, clean bool
+	assumeYes, override         bool
+	identityFiles               []string
+	jobs                        int
+}
+
+func Restore(cfg *config.Config, args []string) error {
+	var ref, targetID string
+	opts := restoreOptions{}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--target":
+			if i+1 < len(args) {
+				targetID = args[i+1]
+				i++
+			} else {
+				return fmt.Errorf("--target flag requires a value")
+			}
+		case arg == "--schema-only":
+			opts.schemaOnly = true
+		case arg == "--data-only":
+			opts.dataOnly = true
+		case arg == "--clean":
+			opts.clean = true
+		case arg == "--yes":
+			opts.assumeYes = true
+		case arg == "--identity":
+			if i+1 < len(args) {
+				opts.identityFiles = append(opts.identityFiles, args[i+1])
+				i++
+			} else {
+				return fmt.Errorf("--identity flag requires a value")
+			}
+		case arg == "--jobs":
+			if i+1 < len(args) {
+				jobs, err := strconv.Atoi(args[i+1])
+				if err != nil || jobs < 1 {
+					return fmt.Errorf("invalid number of jobs '%s'", args[i+1])
+				}
+				opts.jobs = jobs
+				i++
+			} else {
+				return fmt.Errorf("--jobs flag requires a value")
+			}
+		case arg == OverrideProtectionFlag:
+			opts.override = true
+		case !strings.HasPrefix(arg, "--") && ref == "":
+			ref = arg
+		default:
+			return fmt.Errorf("unknown argument: %s", arg)
+		}
+	}
+
+	if ref == "" || targetID == "" {
+		return fmt.Errorf("restore command requires a backup and --target")
+	}
+	if opts.schemaOnly && opts.dataOnly {
+		return fmt.Errorf("--schema-only and --data-only can't be combined")
+	}
+
+	result := newResult("restore", targetID)
+	err := restoreProject(cfg, ref, targetID, opts, result)
+	return printResults(err, result.finish(err))
+}
+
+func restoreProject(cfg *config.Config, ref, targetID string, opts restoreOptions, result *Result) error {
+	targetParams, found := cfg.GetConnection(targetID)
+	if !found {
+		return fmt.Errorf("target project with ID '%s' not found", targetID)
+	}
+	// refuse before verifying, decrypting and the safety backup when the write
+	// could never be confirmed
+	if err := canConfirmWrite(targetID, targetParams, opts.override); err != nil {
+		return err
+	}
+
+	set, err := findBackupSet(cfg.GetBackupRoot(), ref)
+	if err != nil {
+		return err
+	}
+	manifest := set.manifest
+	result.Source = manifest.ProjectID
+	if manifest.Status != ManifestComplete {
+		return fmt.Errorf("backup set %s is incomplete: %s", set.dir, manifest.Error)
+	}
+
+	var parts []ManifestPart
+	for _, name := range []string{"roles", "schema", "data"} {
+		if (opts.schemaOnly && name != "schema") || (opts.dataOnly && name != "data") {
+			continue
+		}
+		part, found := manifest.Part(name)
+		if !found {
+			if opts.schemaOnly || opts.dataOnly {
+				return fmt.Errorf("backup set %s has no %s", set.dir, name)
+			}
+			continue
+		}
+		if err := verifyPart(set.dir, part); err != nil {
+			return err
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("backup set %s has nothing to restore", set.dir)
+	}
+	keys, err := LoadKeyring(cfg, opts.identityFiles)
+	if err != nil {
+		return err
+	}
+	// find out about a missing key before anything is written
+	for _, part := range parts {
+		if err := keys.canDecrypt(filepath.Join(set.dir, part.File)); err != nil {
+			return err
+		}
+	}
+
+	targetParams, err = PrepareConnection(cfg, targetID, targetParams, OpRestore)
+	if err != nil {
+		return err
+	}
+	binaries := cfg.GetBinaryPaths()
+	if binaries.PSQL == "" {
+		return fmt.Errorf("path to psql binary is not set in the config. Please run 'proman init'")
+	}
+	for _, part := range parts {
+		if part.Format != "" {
+			// archives need a pg_restore that can load into the target
+			binaries, err = binariesFor(targetID, targetParams, binaries)
+			if err != nil {
+				return err
+			}
+			break
+		}
+	}
+	jobs := opts.jobs
+	if jobs == 0 {
+		jobs = defaultJobs(targetParams)
+	}
+
+	names := make([]string, len(parts))
+	for i, part := range parts {
+		names[i] = part.Name
+	}
+	utils.InfoPrint("Restoring %s from %s (%s, taken %s) into '%s'\n",
+		strings.Join(names, ", "), set.dir, manifest.ProjectID, manifest.StartedAt.Local().Format("2006-01-02 15:04"), targetID)
+	if opts.clean {
+		utils.WarningPrint("--clean drops the existing objects in '%s' before loading\n", targetID)
+	}
+
+	if !opts.assumeYes {
+		reader := bufio.NewReader(os.Stdin)
+		response, err := utils.Prompt(reader, fmt.Sprintf("Are you sure you want to restore into project '%s'? (y/n): ", targetID))
+		if err != nil {
+			return fmt.Errorf("failed to read user input: %w", err)
+		}
+		if strings.TrimSpace(strings.ToLower(response)) != "y" {
+			utils.ErrorPrint("Restore cancelled by user\n")
+			result.Status = "cancelled"
+			return nil
+		}
+	}
+	if err := confirmWrite(targetID, targetParams, opts.override); err != nil {
+		return err
+	}
+
+	utils.InfoPrint("Taking a safety backup of '%s' first\n", targetID)
+	safety := backupOptions{
+		setName: time.Now().Format("2006-01-02_15-04-05") + "_pre_restore",
+		doRoles: true, doSchema: true, doData: true,
+	}
+	if err := backupProject(cfg, targetID, safety, result); err != nil {
+		return fmt.Errorf("safety backup of '%s' failed, nothing was restored: %w", targetID, err)
+	}
+
+	excludedSchemas := manifest.ExcludedSchemas
+	if len(excludedSchemas) == 0 {
+		excludedSchemas = cfg.GetExcludedSchemas()
+	}
+
+	for _, part := range parts {
+		path := filepath.Join(set.dir, part.File)
+		switch part.Name {
+		case "roles":
+			err = restoreRoles(targetParams, binaries, keys, path)
+		case "schema":
+			if opts.clean {
+				err = runSQL(targetParams, binaries, "Dropping existing schema objects", dropObjectsSQL(excludedSchemas))
+				if err != nil {
+					return fmt.Errorf("failed to clean '%s': %w", targetID, err)
+				}
+			}
+			if part.Format != "" {
+				err = restoreArchive(targetParams, binaries, keys, "Restoring schema", path, jobs)
+			} else {
+				err = loadFile(targetParams, binaries, keys, "Restoring schema", path)
+			}
+		case "data":
+			if opts.clean && opts.dataOnly {
+				err = runSQL(targetParams, binaries, "Emptying existing tables", truncateTablesSQL(excludedSchemas))
+				if err != nil {
+					return fmt.Errorf("failed to clean '%s': %w", targetID, err)
+				}
+			}
+			// replica mode skips triggers and foreign key checks while rows load
+			if part.Format != "" {
+				err = restoreArchive(targetParams, binaries, keys, "Restoring data", path, jobs, "PGOPTIONS=-c session_replication_role=replica")
+			} else {
+				err = loadFile(targetParams, binaries, keys, "Restoring data", path, "SET session_replication_role = replica")
+			}
+			if err == nil {
+				err = runSQL(targetParams, binaries, "Re-syncing sequences", resyncSequencesSQL(excludedSchemas))
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore %s: %w", part.Name, err)
+		}
+	}
+
+	result.setChanged(true)
+	utils.SuccessPrint("Restore complete\n")
+	return nil
+}
+
+// restoreRoles applies the roles part without stopping on errors, since roles
+// that already exist on the target are expected to fail.
+func restoreRoles(params config.ConnectionParams, binaries config.BinaryPaths, keys *Keyring, path string) error {
+	file, input, err := sqlInput(path, binaries, keys)
+	if err != nil {
+		return err
+	}
+
+	spin := utils.NewSpinner("Restoring roles")
+	spin.Start()
+	defer spin.Stop()
+
+	cmd := exec.Command(
+		binaries.PSQL, "-X", "-q",
+		"-h", params.Host, "-p", params.Port, "-U", params.User, "-d", params.DBName,
+		"-f", file,
+	)
+	cmd.Env = connectionEnv(params)
+	cmd.Stdin = input
+	cmd.Stdout = io.Discard
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if input != nil {
+		if closeErr := input.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	if failed := strings.Count(stderr.String(), "ERROR:"); failed > 0 {
+		spin.Stop()
+		utils.WarningPrint("%d role statement(s) failed, usually because the role already exists\n", failed)
+	}
+	return nil
+}
+
+// loadFile runs a SQL file in a single transaction that stops at the first
+// error, after the given setup statements.
+func loadFile(params config.ConnectionParams, binaries config.BinaryPaths, keys *Keyring, message, path string, setup ...string) error {
+	args := []string{"-X", "-q", "-1", "-v", "ON_ERROR_STOP=1"}
+	for _, statement := range setup {
+		args = append(args, "-c", statement)
+	}
+	file, input, err := sqlInput(path, binaries, keys)
+	if err != nil {
+		return err
+	}
+	args = append(args, "-f", file)
+
+	err = psql(params, binaries, message, input, args...)
+	if input != nil {
+		if closeErr := input.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// sqlInput returns what to pass to psql -f for a dump file. Compressed and
+// encrypted dumps are decoded on the fly and fed through stdin.
+func sqlInput(path string, binaries config.BinaryPaths, keys *Keyring) (string, io.ReadCloser, error) {
+	plain, err := isPlainDump(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if plain {
+		return path, nil, nil
+	}
+	input, err := openDump(path, binaries, keys)
+	if err != nil {
+		return "", nil, err
+	}
+	return "-", input, nil
+}
+
+func runSQL(params config.ConnectionParams, binaries config.BinaryPaths, message, sql string) error {
+	return psql(params, binaries, message, nil, "-X", "-q", "-v", "ON_ERROR_STOP=1", "-c", sql)
+}
+
+func psql(params config.ConnectionParams, binaries config.BinaryPaths, message string, stdin io.Reader, args ...string) error {
+	spin := utils.NewSpinner("%s", message)
+	spin.Start()
+	defer spin.Stop()
+
+	args = append([]string{"-h", params.Host, "-p", params.Port, "-U", params.User, "-d", params.DBName}, args...)
+	cmd := exec.Command(binaries.PSQL, args...)
+	cmd.Env = connectionEnv(params)
+	cmd.Stdin = stdin
+	cmd.Stdout = io.Discard
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// userSchemasSQL is a condition on pg_namespace n that leaves out system and
+// excluded schemas.
+func userSchemasSQL(excludedSchemas []string) string {
+	quoted := []string{"'information_schema'"}
+	for _, schema := range excludedSchemas {
+		quoted = append(quoted, "'"+strings.ReplaceAll(schema, "'", "''")+"'")
+	}
+	return fmt.Sprintf(`n.nspname NOT IN (%s) AND n.nspname NOT LIKE 'pg\_%%'`, strings.Join(quoted, ", "))
+}
+
+// dropObjectsSQL drops every user schema except public, and everything in
+// public that doesn't belong to an extension. public itself is kept so its
+// grants and default privileges survive. The statements are collected before
+// any of them run, since cascading drops remove objects later ones refer to.
+func dropObjectsSQL(excludedSchemas []string) string {
+	return fmt.Sprintf(`DO $$
+DECLARE statement text;
+BEGIN
+	FOR statement IN SELECT unnest(
+		ARRAY(SELECT format('DROP SCHEMA IF EXISTS %%I CASCADE', n.nspname) FROM pg_namespace n
+			WHERE %[1]s AND n.nspname <> 'public')
+		|| ARRAY(SELECT format('DROP %%s IF EXISTS public.%%I CASCADE', CASE c.relkind
+				WHEN 'v' THEN 'VIEW' WHEN 'm' THEN 'MATERIALIZED VIEW' WHEN 'f' THEN 'FOREIGN TABLE'
+				WHEN 'S' THEN 'SEQUENCE' ELSE 'TABLE' END, c.relname)
+			FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE n.nspname = 'public' AND c.relkind IN ('r', 'p', 'v', 'm', 'f', 'S')
+			AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.objid = c.oid AND d.deptype = 'e')
+			ORDER BY c.relkind = 'S')
+		|| ARRAY(SELECT format('DROP %%s IF EXISTS %%s CASCADE', CASE p.prokind
+				WHEN 'p' THEN 'PROCEDURE' WHEN 'a' THEN 'AGGREGATE' ELSE 'FUNCTION' END, p.oid::regprocedure::text)
+			FROM pg_proc p JOIN pg_namespace n ON n.oid = p.pronamespace
+			WHERE n.nspname = 'public'
+			AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.objid = p.oid AND d.deptype = 'e'))
+		|| ARRAY(SELECT format('DROP %%s IF EXISTS %%s CASCADE', CASE t.typtype WHEN 'd' THEN 'DOMAIN' ELSE 'TYPE' END, t.oid::regtype::text)
+			FROM pg_type t JOIN pg_namespace n ON n.oid = t.typnamespace
+			WHERE n.nspname = 'public' AND t.typtype IN ('c', 'd', 'e', 'r')
+			AND (t.typrelid = 0 OR (SELECT c.relkind FROM pg_class c WHERE c.oid = t.typrelid) = 'c')
+			AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.objid = t.oid AND d.deptype = 'e'))
+	) LOOP
+		EXECUTE statement;
+	END LOOP;
+END $$`, userSchemasSQL(excludedSchemas))
+}
+
+// truncateTablesSQL empties every table in the user schemas.
+func truncateTablesSQL(excludedSchemas []string) string {
+	return fmt.Sprintf(`DO $$
+DECLARE tables text;
+BEGIN
+	SELECT string_agg(c.oid::regclass::text, ', ') INTO tables FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE %s AND c.relkind IN ('r', 'p')
+		AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.objid = c.oid AND d.deptype = 'e');
+	IF tables IS NOT NULL THEN
+		EXECUTE 'TRUNCATE ' || tables || ' CASCADE';
+	END IF;
+END $$`, userSchemasSQL(excludedSchemas))
+}
+
+// resyncSequencesSQL moves every sequence owned by a column past the largest
+// value in that column, so new rows don't collide with restored ones.
+func resyncSequencesSQL(excludedSchemas []string) string {
+	return fmt.Sprintf(`DO $$
+DECLARE r record; m bigint;
+BEGIN
+	FOR r IN SELECT d.objid::regclass AS seq, c.oid::regclass AS tbl, a.attname AS col
+		FROM pg_depend d
+		JOIN pg_class s ON s.oid = d.objid AND s.relkind = 'S'
+		JOIN pg_class c ON c.oid = d.refobjid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum = d.refobjsubid
+		WHERE d.classid = 'pg_class'::regclass AND d.refclassid = 'pg_class'::regclass
+		AND d.deptype IN ('a', 'i') AND %s LOOP
+		EXECUTE format('SELECT max(%%I) FROM %%s', r.col, r.tbl) INTO m;
+		IF m IS NOT NULL THEN
+			PERFORM setval(r.seq, GREATEST(m, COALESCE(pg_sequence_last_value(r.seq), m)));
+		END IF;
+	END LOOP;
+END $$`, userSchemasSQL(excludedSchemas))
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"proman/utils"
+	"time"
+)
+
+// Result describes one run of a db command. It is printed when --output asks
+// for json, yaml or csv.
+type Result struct {
+	Command   string       `json:"command"`
+	Source    string       `json:"source,omitempty"`
+	Project   string       `json:"project"`
+	Status    string       `json:"status"`
+	ExitCode  int          `json:"exit_code"`
+	Error     string       `json:"error,omitempty"`
+	Changed   *bool        `json:"changed,omitempty"`
+	StartedAt time.Time    `json:"started_at"`
+	Duration  float64      `json:"duration_seconds"`
+	Files     []FileResult `json:"files"`
+}
+
+type FileResult struct {
+	Project   string  `json:"project"`
+	Part      string  `json:"part"`
+	Path      string  `json:"path"`
+	SizeBytes int64   `json:"size_bytes"`
+	Duration  float64 `json:"duration_seconds"`
+}
+
+func newResult(command, project string) *Result {
+	return &Result{
+		Command:   command,
+		Project:   project,
+		StartedAt: time.Now(),
+		Files:     []FileResult{},
+	}
+}
+
+// record runs fn, which writes a file, and adds that file to the result once
+// fn succeeds. fn returns the path it actually wrote.
+func (r *Result) record(project, part string, fn func() (string, error)) (string, error) {
+	start := time.Now()
+	path, err := fn()
+	if err != nil {
+		return "", err
+	}
+
+	file := FileResult{
+		Project:  project,
+		Part:     part,
+		Path:     path,
+		Duration: time.Since(start).Seconds(),
+	}
+	if info, err := os.Stat(path); err == nil {
+		file.SizeBytes = info.Size()
+	}
+	r.Files = append(r.Files, file)
+	return path, nil
+}
+
+func (r *Result) setChanged(changed bool) {
+	r.Changed = &changed
+}
+
+func (r *Result) finish(err error) *Result {
+	r.Duration = time.Since(r.StartedAt).Seconds()
+	if err == nil {
+		if r.Status == "" {
+			r.Status = "ok"
+		}
+		return r
+	}
+
+	r.Status = "failed"
+	r.Error = err.Error()
+	r.ExitCode = 1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		r.ExitCode = exitErr.ExitCode()
+	}
+	return r
+}
+
+// printResults writes v when structured output was asked for and passes the
+// command's own error through.
+func printResults(err error, v any) error {
+	if !utils.MachineOutput() {
+		return err
+	}
+	if writeErr := utils.WriteOutput(v); writeErr != nil && err == nil {
+		return writeErr
+	}
+	return err
+}
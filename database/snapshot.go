@@ -0,0 +1,83 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"proman/config"
+	"strings"
+)
+
+// exportSnapshotSQL opens the transaction whose snapshot the dumps share and
+// reports it with the WAL position it was taken at. A standby has no WAL of
+// its own, so its replay position is used there.
+const exportSnapshotSQL = `BEGIN TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY;
+SELECT pg_export_snapshot(), CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END;
+`
+
+// heldSnapshot is an exported snapshot along with the psql session keeping
+// its transaction open. pg_dump can only import it while that lasts.
+type heldSnapshot struct {
+	ID  string
+	LSN string
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr *bytes.Buffer
+}
+
+// exportSnapshot starts a transaction on the server and exports its snapshot,
+// so separate pg_dump runs see the database at the same moment.
+func exportSnapshot(params config.ConnectionParams, binaries config.BinaryPaths) (*heldSnapshot, error) {
+	cmd := exec.Command(
+		binaries.PSQL, "-X", "-q", "-A", "-t", "-v", "ON_ERROR_STOP=1",
+		"-h", params.Host, "-p", params.Port, "-U", params.User, "-d", params.DBName,
+	)
+	cmd.Env = connectionEnv(params)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	fail := func(err error) error {
+		stdin.Close()
+		cmd.Wait()
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.New(msg)
+		}
+		return err
+	}
+
+	if _, err := io.WriteString(stdin, exportSnapshotSQL); err != nil {
+		return nil, fail(err)
+	}
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	fields := strings.Split(strings.TrimSpace(line), "|")
+	if err != nil || len(fields) != 2 || fields[0] == "" {
+		return nil, fail(fmt.Errorf("unexpected reply %q", strings.TrimSpace(line)))
+	}
+
+	return &heldSnapshot{ID: fields[0], LSN: fields[1], cmd: cmd, stdin: stdin, stderr: &stderr}, nil
+}
+
+// release ends the transaction, after which the snapshot can't be imported.
+func (s *heldSnapshot) release() error {
+	io.WriteString(s.stdin, "COMMIT;\n")
+	s.stdin.Close()
+	if err := s.cmd.Wait(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(s.stderr.String()))
+	}
+	return nil
+}
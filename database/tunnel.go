@@ -0,0 +1,233 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"proman/config"
+	"proman/utils"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+type tunnel struct {
+	cmd       *exec.Cmd
+	localPort string
+	stderr    bytes.Buffer
+	exited    chan error
+}
+
+var (
+	tunnelsMu      sync.Mutex
+	tunnels        = map[string]*tunnel{}
+	tunnelSignals  sync.Once
+	tunnelWaitTime = 15 * time.Second
+
+	// interrupted is set and interrupts closed once a signal has arrived,
+	// after which no new tunnels are opened
+	interrupted    atomic.Bool
+	interrupts     = make(chan struct{})
+	errInterrupted = errors.New("interrupted")
+
+	// errPortTaken means something else bound the local port between freePort
+	// and ssh, so the forward is retried on another one
+	errPortTaken   = errors.New("local port already in use")
+	tunnelAttempts = 3
+)
+
+// PrepareConnection readies a connection for the pg tools: the password is
+// resolved, the endpoint suited to op is picked and, when the connection goes
+// through a bastion, an SSH port forward is opened and the returned params
+// point at it. Tunnels stay open until CloseTunnels is called.
+func PrepareConnection(cfg *config.Config, projectID string, params config.ConnectionParams, op Operation) (config.ConnectionParams, error) {
+	params, err := cfg.ResolvePassword(projectID, params)
+	if err != nil {
+		return params, err
+	}
+
+	params, err = selectEndpoint(projectID, params, op)
+	if err != nil {
+		return params, err
+	}
+
+	if rootCert, missing := MissingRootCert(params); missing {
+		utils.WarningPrint(
+			"Project '%s' uses sslmode=%s without an sslrootcert, and %s doesn't exist, so the server "+
+				"can't be verified. Download the server's CA certificate (Supabase offers it under the "+
+				"project's database settings) and set it as the connection's sslrootcert in the config, "+
+				"or run 'proman init' to be asked for Supabase's\n",
+			projectID, SSLMode(params), rootCert,
+		)
+	}
+
+	if params.SSH == nil || params.SSH.Host == "" {
+		return params, nil
+	}
+
+	t, err := openTunnel(projectID, params, cfg.GetBinaryPaths())
+	if err != nil {
+		return params, fmt.Errorf("failed to open SSH tunnel for '%s': %w", projectID, err)
+	}
+
+	// keep Host for TLS verification and connect through the forward instead
+	params.HostAddr = "127.0.0.1"
+	params.Port = t.localPort
+	return params, nil
+}
+
+func openTunnel(projectID string, params config.ConnectionParams, binaries config.BinaryPaths) (*tunnel, error) {
+	tunnelsMu.Lock()
+	defer tunnelsMu.Unlock()
+
+	// a project can be reached through several endpoints, so key on the target too
+	key := projectID + "|" + net.JoinHostPort(params.Host, params.Port)
+	if t, found := tunnels[key]; found {
+		return t, nil
+	}
+
+	if interrupted.Load() {
+		return nil, errInterrupted
+	}
+
+	tunnelSignals.Do(func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		go func() {
+			<-signals
+			// the tools connected through the tunnels fail once they close, so
+			// the command returns through its usual cleanup
+			interrupted.Store(true)
+			close(interrupts)
+			utils.WarningPrint("\nInterrupted, cleaning up. Interrupt again to quit immediately\n")
+			CloseTunnels()
+			<-signals
+			os.Exit(1)
+		}()
+	})
+
+	sshPath := binaries.SSH
+	if sshPath == "" {
+		sshPath = "ssh"
+	}
+
+	for attempt := 1; ; attempt++ {
+		localPort, err := freePort()
+		if err != nil {
+			return nil, err
+		}
+		t, err := startTunnel(sshPath, params, localPort)
+		if errors.Is(err, errPortTaken) && attempt < tunnelAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		tunnels[key] = t
+		return t, nil
+	}
+}
+
+// startTunnel runs ssh forwarding localPort to the database and waits until the
+// forward accepts connections, ssh exits or a signal arrives.
+func startTunnel(sshPath string, params config.ConnectionParams, localPort string) (*tunnel, error) {
+	ssh := params.SSH
+	args := []string{
+		"-N",
+		"-L", fmt.Sprintf("127.0.0.1:%s:%s", localPort, net.JoinHostPort(params.Host, params.Port)),
+		"-o", "ExitOnForwardFailure=yes",
+		"-o", "BatchMode=yes",
+		"-o", "ServerAliveInterval=15",
+		"-o", "StrictHostKeyChecking=yes",
+	}
+	if ssh.Port != "" {
+		args = append(args, "-p", ssh.Port)
+	}
+	if ssh.KeyPath != "" {
+		args = append(args, "-i", ssh.KeyPath, "-o", "IdentitiesOnly=yes")
+	}
+	if ssh.KnownHosts != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+ssh.KnownHosts)
+	}
+	destination := ssh.Host
+	if ssh.User != "" {
+		destination = ssh.User + "@" + ssh.Host
+	}
+	args = append(args, destination)
+
+	t := &tunnel{localPort: localPort}
+	t.cmd = exec.Command(sshPath, args...)
+	t.cmd.Stderr = &t.stderr
+	if err := t.cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	t.exited = make(chan error, 1)
+	go func() { t.exited <- t.cmd.Wait() }()
+
+	deadline := time.Now().Add(tunnelWaitTime)
+	for {
+		select {
+		case err := <-t.exited:
+			stderr := bytes.TrimSpace(t.stderr.Bytes())
+			if bytes.Contains(stderr, []byte("Address already in use")) {
+				return nil, fmt.Errorf("%w: %s", errPortTaken, stderr)
+			}
+			return nil, fmt.Errorf("ssh exited: %v: %s", err, stderr)
+		case <-interrupts:
+			t.cmd.Process.Kill()
+			<-t.exited
+			return nil, errInterrupted
+		default:
+		}
+
+		conn, err := net.DialTimeout("tcp", "127.0.0.1:"+localPort, time.Second)
+		if err == nil {
+			conn.Close()
+			return t, nil
+		}
+		if time.Now().After(deadline) {
+			t.cmd.Process.Kill()
+			<-t.exited
+			return nil, fmt.Errorf("timed out waiting for the port forward to %s", ssh.Host)
+		}
+		select {
+		case <-interrupts:
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// CloseTunnels tears down every SSH tunnel opened by this process.
+func CloseTunnels() {
+	tunnelsMu.Lock()
+	defer tunnelsMu.Unlock()
+
+	for key, t := range tunnels {
+		if err := t.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			t.cmd.Process.Kill()
+		}
+		select {
+		case <-t.exited:
+		case <-time.After(3 * time.Second):
+			t.cmd.Process.Kill()
+			<-t.exited
+		}
+		delete(tunnels, key)
+	}
+}
+
+func freePort() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to find a free local port: %w", err)
+	}
+	defer listener.Close()
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port), nil
+}
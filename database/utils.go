@@ -2,12 +2,144 @@ package database
 
 import (
 	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
 	"proman/config"
+	"runtime"
+	"strings"
 )
 
+// SSLMode returns the sslmode used for a connection. Supabase-hosted databases
+// default to verify-full; everything else keeps libpq's own default.
+func SSLMode(params config.ConnectionParams) string {
+	if params.SSLMode != "" {
+		return params.SSLMode
+	}
+	if IsSupabaseHost(params.Host) {
+		return "verify-full"
+	}
+	return "prefer"
+}
+
+// MissingRootCert reports the CA file libpq will look for when a connection
+// verifies the server certificate without an sslrootcert of its own, and that
+// file doesn't exist. Such a connection fails before logging in.
+func MissingRootCert(params config.ConnectionParams) (string, bool) {
+	mode := SSLMode(params)
+	if mode != "verify-ca" && mode != "verify-full" {
+		return "", false
+	}
+	if params.SSLRootCert != "" || os.Getenv("PGSSLROOTCERT") != "" {
+		return "", false
+	}
+
+	var path string
+	if runtime.GOOS == "windows" {
+		path = filepath.Join(os.Getenv("APPDATA"), "postgresql", "root.crt")
+	} else {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", false
+		}
+		path = filepath.Join(home, ".postgresql", "root.crt")
+	}
+	if _, err := os.Stat(path); err == nil {
+		return "", false
+	}
+	return path, true
+}
+
+func IsSupabaseHost(host string) bool {
+	host = strings.ToLower(host)
+	return strings.HasSuffix(host, ".supabase.co") || strings.HasSuffix(host, ".supabase.com")
+}
+
+// sslOptions lists the libpq TLS settings of a connection as key/value pairs.
+func sslOptions(params config.ConnectionParams) [][2]string {
+	options := [][2]string{{"sslmode", SSLMode(params)}}
+	if params.SSLRootCert != "" {
+		options = append(options, [2]string{"sslrootcert", params.SSLRootCert})
+	}
+	if params.SSLCert != "" {
+		options = append(options, [2]string{"sslcert", params.SSLCert})
+	}
+	if params.SSLKey != "" {
+		options = append(options, [2]string{"sslkey", params.SSLKey})
+	}
+	return options
+}
+
+// connectionEnv builds the environment for psql, pg_dump and pg_dumpall so the
+// password and TLS settings never have to appear on the command line.
+func connectionEnv(params config.ConnectionParams) []string {
+	env := append(os.Environ(), "PGPASSWORD="+params.Password)
+	for _, option := range sslOptions(params) {
+		env = append(env, "PG"+strings.ToUpper(option[0])+"="+option[1])
+	}
+	if params.HostAddr != "" {
+		env = append(env, "PGHOSTADDR="+params.HostAddr)
+	}
+	return env
+}
+
 func FormatRemoteConnectionString(connection config.ConnectionParams) string {
-	return fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		connection.User, connection.Password, connection.Host, connection.Port, connection.DBName,
-	)
+	query := url.Values{}
+	for _, option := range sslOptions(connection) {
+		query.Set(option[0], option[1])
+	}
+
+	host := connection.Host
+	if connection.HostAddr != "" {
+		// not every client understands hostaddr, so connect to the address
+		// directly. The certificate chain is still checked, but its name can't
+		// match the address.
+		host = connection.HostAddr
+		if query.Get("sslmode") == "verify-full" {
+			query.Set("sslmode", "verify-ca")
+		}
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(connection.User, connection.Password),
+		Host:     net.JoinHostPort(host, connection.Port),
+		Path:     "/" + connection.DBName,
+		RawQuery: query.Encode(),
+	}
+	if connection.Password == "" {
+		u.User = url.User(connection.User)
+	}
+	return u.String()
+}
+
+// ParseConnectionString turns a postgres:// URI into connection parameters.
+// Parts missing from the URI are left empty so callers can apply their own defaults.
+func ParseConnectionString(raw string) (config.ConnectionParams, error) {
+	params := config.ConnectionParams{}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return params, fmt.Errorf("invalid connection URL: %w", err)
+	}
+	if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+		return params, fmt.Errorf("unsupported connection URL scheme '%s', expected postgres://", u.Scheme)
+	}
+
+	params.Host = u.Hostname()
+	params.Port = u.Port()
+	if u.User != nil {
+		params.User = u.User.Username()
+		params.Password, _ = u.User.Password()
+	}
+	params.DBName = strings.TrimPrefix(u.Path, "/")
+
+	query := u.Query()
+	params.SSLMode = query.Get("sslmode")
+	params.SSLRootCert = query.Get("sslrootcert")
+	params.SSLCert = query.Get("sslcert")
+	params.SSLKey = query.Get("sslkey")
+
+	return params, nil
 }
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"proman/config"
+	"proman/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	serverVersionsMu sync.Mutex
+	serverVersions   = map[string]int{}
+)
+
+// ServerMajorVersion asks the server for its major version. Results are cached
+// per project for the rest of the process.
+func ServerMajorVersion(projectID string, params config.ConnectionParams, binaries config.BinaryPaths) (int, error) {
+	serverVersionsMu.Lock()
+	defer serverVersionsMu.Unlock()
+
+	if version, found := serverVersions[projectID]; found {
+		return version, nil
+	}
+
+	if binaries.PSQL == "" {
+		return 0, fmt.Errorf("path to psql binary is not set in the config. Please run 'proman init'")
+	}
+
+	out, err := queryServer(params, binaries, "SHOW server_version_num")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query the server version of '%s': %w", projectID, err)
+	}
+	number, err := strconv.Atoi(out)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected server version %q from '%s'", out, projectID)
+	}
+
+	version := number / 10000
+	serverVersions[projectID] = version
+	return version, nil
+}
+
+// ServerVersion returns the server's full version string, e.g. "15.8".
+func ServerVersion(params config.ConnectionParams, binaries config.BinaryPaths) (string, error) {
+	return queryServer(params, binaries, "SHOW server_version")
+}
+
+// queryServer runs a single-value query with psql and returns the trimmed result.
+func queryServer(params config.ConnectionParams, binaries config.BinaryPaths, query string) (string, error) {
+	cmd := exec.Command(
+		binaries.PSQL, "-X", "-A", "-t",
+		"-h", params.Host, "-p", params.Port, "-U", params.User, "-d", params.DBName,
+		"-c", query,
+	)
+	cmd.Env = connectionEnv(params)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.New(strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+type installation struct {
+	major int
+	tools config.PGTools
+}
+
+// binariesFor returns the binaries to use against a project. pg_dump and
+// pg_dumpall refuse to dump servers newer than themselves, so the installation
+// with the closest major version at or above the server's is picked from the
+// default binaries and every installation listed under binaries.versions.
+func binariesFor(projectID string, params config.ConnectionParams, binaries config.BinaryPaths) (config.BinaryPaths, error) {
+	var candidates []installation
+	for key, tools := range binaries.Versions {
+		major, err := strconv.Atoi(key)
+		if err != nil {
+			return binaries, fmt.Errorf("binaries.versions key '%s' is not a major version number", key)
+		}
+		candidates = append(candidates, installation{major, tools})
+	}
+	if binaries.PGDump != "" {
+		if version, err := utils.BinaryVersion("pg_dump", binaries.PGDump); err == nil {
+			major, _ := strconv.Atoi(strings.Split(version, ".")[0])
+			candidates = append(candidates, installation{major, config.PGTools{
+				PSQL:      binaries.PSQL,
+				PGDump:    binaries.PGDump,
+				PGDumpAll: binaries.PGDumpAll,
+				PGRestore: binaries.PGRestore,
+			}})
+		}
+	}
+	if len(candidates) == 0 {
+		// nothing to choose from, let pg_dump report any mismatch itself
+		return binaries, nil
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].major < candidates[j].major })
+
+	serverMajor, err := ServerMajorVersion(projectID, params, binaries)
+	if err != nil {
+		return binaries, err
+	}
+
+	available := []string{}
+	for _, candidate := range candidates {
+		available = append(available, strconv.Itoa(candidate.major))
+		if candidate.major < serverMajor || candidate.tools.PGDump == "" {
+			continue
+		}
+
+		selected := binaries
+		selected.PGDump = candidate.tools.PGDump
+		if candidate.tools.PGDumpAll != "" {
+			selected.PGDumpAll = candidate.tools.PGDumpAll
+		}
+		if candidate.tools.PSQL != "" {
+			selected.PSQL = candidate.tools.PSQL
+		}
+		// an unset pg_restore is found next to the selected pg_dump
+		selected.PGRestore = candidate.tools.PGRestore
+		return selected, nil
+	}
+
+	return binaries, fmt.Errorf(
+		"project '%s' runs PostgreSQL %d, but no pg_dump %d or newer is configured (available: %s). "+
+			"Install a matching version and add it with 'proman init'",
+		projectID, serverMajor, serverMajor, strings.Join(available, ", "),
+	)
+}
+
+// PGRestorePath returns the configured pg_restore, or the one installed next
+// to pg_dump.
+func PGRestorePath(binaries config.BinaryPaths) string {
+	if binaries.PGRestore != "" {
+		return binaries.PGRestore
+	}
+	if dir := filepath.Dir(binaries.PGDump); binaries.PGDump != "" && dir != "." {
+		return filepath.Join(dir, "pg_restore"+filepath.Ext(binaries.PGDump))
+	}
+	return "pg_restore"
+}
@@ -6,6 +6,8 @@ require (
 	github.com/briandowns/spinner v1.23.2
 	github.com/fatih/color v1.7.0
 	github.com/ugurcsen/gods-generic v0.10.4
+	golang.org/x/sys v0.28.0
+	golang.org/x/term v0.27.0
 )
 
 require (
@@ -19,7 +21,5 @@ require (
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/satori/go.uuid v1.2.0 // indirect
 	github.com/urfave/cli v1.22.17 // indirect
-	golang.org/x/sys v0.28.0 // indirect
-	golang.org/x/term v0.27.0 // indirect
 	golang.org/x/text v0.28.0 // indirect
 )
@@ -0,0 +1,374 @@
+package projects
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"proman/config"
+	"proman/database"
+	"proman/utils"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type checkStatus string
+
+const (
+	statusPass checkStatus = "pass"
+	statusWarn checkStatus = "warn"
+	statusFail checkStatus = "fail"
+)
+
+type checkResult struct {
+	Check   string      `json:"check"`
+	Status  checkStatus `json:"status"`
+	Message string      `json:"message"`
+	Hint    string      `json:"hint,omitempty"`
+}
+
+type doctorReport struct {
+	OS      string        `json:"os"`
+	Config  []string      `json:"config"`
+	Results []checkResult `json:"results"`
+}
+
+func Doctor(cfg *config.Config, configFile string, args []string) error {
+	asJSON := false
+	for _, arg := range args {
+		switch arg {
+		case "--json":
+			asJSON = true
+		default:
+			return fmt.Errorf("unknown flag: %s", arg)
+		}
+	}
+
+	var results []checkResult
+	results = append(results, checkBinaries(cfg.GetBinaryPaths())...)
+	results = append(results, checkSupabaseLogin(cfg.GetBinaryPaths()))
+	results = append(results, checkDocker())
+	results = append(results, checkWritableDirs(cfg)...)
+	results = append(results, checkConfigPermissions(cfg, configFile))
+	results = append(results, checkDiffViewer(cfg))
+	if zstd, found := checkZstd(cfg); found {
+		results = append(results, zstd)
+	}
+	results = append(results, checkConnections(cfg)...)
+
+	failed := 0
+	for _, result := range results {
+		if result.Status == statusFail {
+			failed++
+		}
+	}
+
+	if asJSON {
+		utils.SetOutputFormat(utils.OutputJSON)
+	}
+	if utils.MachineOutput() {
+		err := utils.WriteOutput(doctorReport{
+			OS:      runtime.GOOS + "/" + runtime.GOARCH,
+			Config:  cfg.Files(),
+			Results: results,
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, result := range results {
+			printResult(result)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	if !utils.MachineOutput() {
+		utils.SuccessPrint("\nNo problems found\n")
+	}
+	return nil
+}
+
+func printResult(result checkResult) {
+	switch result.Status {
+	case statusPass:
+		utils.SuccessPrint("[PASS] ")
+	case statusWarn:
+		utils.WarningPrint("[WARN] ")
+	default:
+		utils.ErrorPrint("[FAIL] ")
+	}
+	fmt.Fprintf(os.Stderr, "%s: %s\n", result.Check, result.Message)
+	if result.Hint != "" {
+		utils.InfoPrint("       %s\n", result.Hint)
+	}
+}
+
+func checkBinary(name, tool, path string) checkResult {
+	result := checkResult{Check: name}
+	if path == "" {
+		result.Status = statusFail
+		result.Message = "not configured"
+		result.Hint = "Run 'proman init' to set the path"
+		return result
+	}
+	version, err := utils.BinaryVersion(tool, path)
+	if err != nil {
+		result.Status = statusFail
+		result.Message = err.Error()
+		result.Hint = "Run 'proman init' to pick a working installation"
+		return result
+	}
+	result.Status = statusPass
+	result.Message = fmt.Sprintf("%s %s", path, version)
+	return result
+}
+
+func checkBinaries(binaries config.BinaryPaths) []checkResult {
+	results := []checkResult{
+		checkBinary("psql", "psql", binaries.PSQL),
+		checkBinary("pg_dump", "pg_dump", binaries.PGDump),
+		checkBinary("pg_dumpall", "pg_dumpall", binaries.PGDumpAll),
+		checkBinary("supabase", "supabase", binaries.Supabase),
+	}
+
+	// only custom and directory format backups need pg_restore
+	restore := checkBinary("pg_restore", "pg_restore", database.PGRestorePath(binaries))
+	if restore.Status == statusFail {
+		restore.Status = statusWarn
+		restore.Hint = "Custom and directory format backups are restored with pg_restore. Set binaries.pg_restore in the config"
+	}
+	results = append(results, restore)
+
+	majors := make([]string, 0, len(binaries.Versions))
+	for major := range binaries.Versions {
+		majors = append(majors, major)
+	}
+	sort.Strings(majors)
+	for _, major := range majors {
+		tools := binaries.Versions[major]
+		for _, check := range []struct{ tool, path string }{
+			{"psql", tools.PSQL}, {"pg_dump", tools.PGDump}, {"pg_dumpall", tools.PGDumpAll}, {"pg_restore", tools.PGRestore},
+		} {
+			if check.path == "" {
+				continue
+			}
+			result := checkBinary(fmt.Sprintf("%s (PostgreSQL %s)", check.tool, major), check.tool, check.path)
+			if version, err := utils.BinaryVersion(check.tool, check.path); err == nil && strings.Split(version, ".")[0] != major {
+				result.Status = statusWarn
+				result.Hint = fmt.Sprintf("Listed under version %s but reports %s. Fix binaries.versions in the config", major, version)
+			}
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+func runWithTimeout(timeout time.Duration, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+func checkSupabaseLogin(binaries config.BinaryPaths) checkResult {
+	result := checkResult{Check: "supabase login"}
+	if os.Getenv("SUPABASE_ACCESS_TOKEN") != "" {
+		result.Status = statusPass
+		result.Message = "using SUPABASE_ACCESS_TOKEN"
+		return result
+	}
+	if binaries.Supabase == "" {
+		result.Status = statusWarn
+		result.Message = "skipped, the supabase binary is not configured"
+		return result
+	}
+
+	if _, err := runWithTimeout(30*time.Second, binaries.Supabase, "projects", "list"); err != nil {
+		result.Status = statusWarn
+		result.Message = "the supabase CLI is not logged in"
+		result.Hint = "Run 'proman supabase login'. gen-types needs it"
+		return result
+	}
+	result.Status = statusPass
+	result.Message = "logged in"
+	return result
+}
+
+func checkDocker() checkResult {
+	result := checkResult{Check: "docker"}
+	if _, err := exec.LookPath("docker"); err != nil {
+		result.Status = statusWarn
+		result.Message = "docker is not installed"
+		result.Hint = "db clone and db gen-migration start a local Supabase stack and need Docker"
+		return result
+	}
+	out, err := runWithTimeout(20*time.Second, "docker", "info", "--format", "{{.ServerVersion}}")
+	if err != nil {
+		result.Status = statusWarn
+		result.Message = "the Docker daemon is not reachable"
+		result.Hint = "Start Docker; db clone and db gen-migration need it"
+		return result
+	}
+	result.Status = statusPass
+	result.Message = "server " + strings.TrimSpace(string(out))
+	return result
+}
+
+func checkWritable(name, dir string) checkResult {
+	result := checkResult{Check: name}
+	file, err := os.CreateTemp(dir, ".proman_doctor_*")
+	if err != nil {
+		result.Status = statusFail
+		result.Message = fmt.Sprintf("%s is not writable: %v", dir, err)
+		result.Hint = "Fix the directory permissions or free up space"
+		return result
+	}
+	file.Close()
+	os.Remove(file.Name())
+	result.Status = statusPass
+	result.Message = dir
+	return result
+}
+
+func checkWritableDirs(cfg *config.Config) []checkResult {
+	// the backup root is created on the first backup, so check where it would go
+	backupDir := cfg.GetBackupRoot()
+	for {
+		if _, err := os.Stat(backupDir); err == nil || filepath.Dir(backupDir) == backupDir {
+			break
+		}
+		backupDir = filepath.Dir(backupDir)
+	}
+	return []checkResult{
+		checkWritable("temp directory", os.TempDir()),
+		checkWritable("backup directory", backupDir),
+	}
+}
+
+func checkConfigPermissions(cfg *config.Config, configFile string) checkResult {
+	result := checkResult{Check: "config permissions"}
+	info, err := os.Stat(configFile)
+	if err != nil {
+		result.Status = statusFail
+		result.Message = err.Error()
+		return result
+	}
+
+	plaintext := cfg.PlaintextPasswords()
+	worldReadable := runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0
+
+	switch {
+	case len(plaintext) > 0 && worldReadable:
+		result.Status = statusFail
+		result.Message = fmt.Sprintf("%s is %s and holds %d plaintext password(s)", configFile, info.Mode().Perm(), len(plaintext))
+		result.Hint = fmt.Sprintf("Run 'proman vault migrate' and 'chmod 600 %s'", configFile)
+	case len(plaintext) > 0:
+		result.Status = statusWarn
+		result.Message = fmt.Sprintf("%d password(s) are stored in plaintext", len(plaintext))
+		result.Hint = "Run 'proman vault migrate' to encrypt them"
+	case worldReadable:
+		result.Status = statusWarn
+		result.Message = fmt.Sprintf("%s is readable by other users (%s)", configFile, info.Mode().Perm())
+		result.Hint = fmt.Sprintf("Run 'chmod 600 %s'", configFile)
+	default:
+		result.Status = statusPass
+		result.Message = fmt.Sprintf("%s (%s)", configFile, info.Mode().Perm())
+	}
+	return result
+}
+
+func checkDiffViewer(cfg *config.Config) checkResult {
+	result := checkResult{Check: "diff viewer"}
+	command := utils.DiffViewerCommand(cfg.Editor.Default)
+	path, err := exec.LookPath(command)
+	if err != nil {
+		result.Status = statusWarn
+		result.Message = fmt.Sprintf("'%s' was not found on PATH", command)
+		result.Hint = "Install it or pick another viewer with 'proman init'. db diff needs it"
+		return result
+	}
+	result.Status = statusPass
+	result.Message = fmt.Sprintf("%s (%s)", command, filepath.Clean(path))
+	return result
+}
+
+// checkZstd is only reported when a connection compresses its backups with zstd.
+func checkZstd(cfg *config.Config) (checkResult, bool) {
+	var users []string
+	for _, id := range cfg.ListConnections() {
+		if params, _ := cfg.GetConnection(id); params.Compress == database.CompressZstd {
+			users = append(users, id)
+		}
+	}
+	if len(users) == 0 {
+		return checkResult{}, false
+	}
+	sort.Strings(users)
+
+	result := checkResult{Check: "zstd"}
+	command := cfg.GetBinaryPaths().Zstd
+	if command == "" {
+		command = "zstd"
+	}
+	path, err := exec.LookPath(command)
+	if err != nil {
+		result.Status = statusFail
+		result.Message = fmt.Sprintf("'%s' was not found, but %s compress backups with zstd", command, strings.Join(users, ", "))
+		result.Hint = "Install zstd or set binaries.zstd in the config"
+		return result, true
+	}
+	result.Status = statusPass
+	result.Message = filepath.Clean(path)
+	return result, true
+}
+
+func checkConnections(cfg *config.Config) []checkResult {
+	ids := cfg.ListConnections()
+	sort.Strings(ids)
+
+	results := make([]checkResult, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		results[i].Check = "connection " + id
+
+		params, _ := cfg.GetConnection(id)
+		params, err := database.PrepareConnection(cfg, id, params, database.OpProbe)
+		if err != nil {
+			results[i].Status = statusFail
+			results[i].Message = err.Error()
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, params config.ConnectionParams) {
+			defer wg.Done()
+			status := database.CheckConnection(params, cfg.GetBinaryPaths())
+			if !status.OK() {
+				results[i].Status = statusFail
+				results[i].Message = status.Err.Error()
+				results[i].Hint = fmt.Sprintf("Run 'proman connection test %s' for details", ids[i])
+				if rootCert, missing := database.MissingRootCert(params); missing {
+					results[i].Hint = fmt.Sprintf("%s doesn't exist; set sslrootcert to the server's CA certificate", rootCert)
+				}
+				return
+			}
+			results[i].Status = statusPass
+			tls := "no TLS"
+			if status.TLS {
+				tls = "TLS"
+			}
+			latency := status.Latency.Round(time.Millisecond).String()
+			if configured, _ := cfg.GetConnection(ids[i]); configured.SSH != nil && configured.SSH.Host != "" {
+				latency += " to the local SSH tunnel end"
+			}
+			results[i].Message = fmt.Sprintf("PostgreSQL %s, %s, %s", status.Version, tls, latency)
+		}(i, params)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,206 @@
+package projects
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"proman/config"
+	"proman/database"
+	"proman/utils"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+var exporters = map[string]func(w io.Writer, ids []string, connections map[string]config.ConnectionParams) error{
+	"pgservice": exportPGService,
+	"pgpass":    exportPGPass,
+	"dotenv":    exportDotenv,
+	"json":      exportJSON,
+}
+
+func Export(cfg *config.Config, args []string) error {
+	var format, outputFile string
+	var selectors, tags []string
+	withPasswords := true
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--format":
+			if i+1 < len(args) {
+				format = args[i+1]
+				i++
+			} else {
+				return fmt.Errorf("--format flag requires a value")
+			}
+		case arg == "--file":
+			if i+1 < len(args) {
+				outputFile = args[i+1]
+				i++
+			} else {
+				return fmt.Errorf("--file flag requires a value")
+			}
+		case arg == "--tag":
+			if i+1 < len(args) {
+				tags = append(tags, args[i+1])
+				i++
+			} else {
+				return fmt.Errorf("--tag flag requires a value")
+			}
+		case arg == "--no-passwords":
+			withPasswords = false
+		case !strings.HasPrefix(arg, "--"):
+			selectors = append(selectors, arg)
+		default:
+			return fmt.Errorf("unknown flag: %s", arg)
+		}
+	}
+
+	exporter, found := exporters[format]
+	if !found {
+		return fmt.Errorf("--format must be one of pgservice, pgpass, dotenv or json")
+	}
+	if format == "pgpass" && !withPasswords {
+		return fmt.Errorf("the pgpass format only holds passwords, so it can't be combined with --no-passwords")
+	}
+
+	var ids []string
+	if len(selectors) == 0 && len(tags) == 0 {
+		ids = cfg.ListConnections()
+	} else {
+		var err error
+		ids, err = cfg.ResolveTargets(selectors, tags)
+		if err != nil {
+			return err
+		}
+	}
+	if len(ids) == 0 {
+		utils.WarningPrint("No projects are registered yet. Use 'proman register' to add one")
+		return nil
+	}
+	sort.Strings(ids)
+
+	connections := make(map[string]config.ConnectionParams, len(ids))
+	for _, id := range ids {
+		params, _ := cfg.GetConnection(id)
+		if withPasswords {
+			var err error
+			params, err = cfg.ResolvePassword(id, params)
+			if err != nil {
+				return err
+			}
+		} else {
+			params.Password = ""
+		}
+		connections[id] = params
+	}
+
+	var out bytes.Buffer
+	if err := exporter(&out, ids, connections); err != nil {
+		return err
+	}
+
+	if outputFile == "" {
+		if withPasswords && hasPasswords(connections) && term.IsTerminal(int(os.Stdout.Fd())) {
+			utils.WarningPrint("The export includes passwords. Use --file to write them to a private file, or --no-passwords\n")
+		}
+		_, err := os.Stdout.Write(out.Bytes())
+		return err
+	}
+
+	// the export can contain passwords, so keep it private like .pgpass
+	file, err := os.OpenFile(outputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	if _, err := file.Write(out.Bytes()); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", outputFile, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", outputFile, err)
+	}
+
+	utils.SuccessPrint("Exported %d connection(s) to %s\n", len(ids), outputFile)
+	return nil
+}
+
+func hasPasswords(connections map[string]config.ConnectionParams) bool {
+	for _, params := range connections {
+		if params.Password != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func exportPGService(w io.Writer, ids []string, connections map[string]config.ConnectionParams) error {
+	for i, id := range ids {
+		params := connections[id]
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "[%s]\n", id)
+		fmt.Fprintf(w, "host=%s\nport=%s\ndbname=%s\nuser=%s\n", params.Host, params.Port, params.DBName, params.User)
+		if params.Password != "" {
+			fmt.Fprintf(w, "password=%s\n", params.Password)
+		}
+		fmt.Fprintf(w, "sslmode=%s\n", database.SSLMode(params))
+		if params.SSLRootCert != "" {
+			fmt.Fprintf(w, "sslrootcert=%s\n", params.SSLRootCert)
+		}
+		if params.SSLCert != "" {
+			fmt.Fprintf(w, "sslcert=%s\n", params.SSLCert)
+		}
+		if params.SSLKey != "" {
+			fmt.Fprintf(w, "sslkey=%s\n", params.SSLKey)
+		}
+	}
+	return nil
+}
+
+var pgpassEscaper = strings.NewReplacer(`\`, `\\`, `:`, `\:`)
+
+func exportPGPass(w io.Writer, ids []string, connections map[string]config.ConnectionParams) error {
+	for _, id := range ids {
+		params := connections[id]
+		fmt.Fprintf(w, "# %s\n%s:%s:%s:%s:%s\n",
+			id,
+			pgpassEscaper.Replace(params.Host),
+			pgpassEscaper.Replace(params.Port),
+			pgpassEscaper.Replace(params.DBName),
+			pgpassEscaper.Replace(params.User),
+			pgpassEscaper.Replace(params.Password),
+		)
+	}
+	return nil
+}
+
+var nonEnvChars = regexp.MustCompile(`[^A-Z0-9_]+`)
+
+// exportDotenv writes DATABASE_URL for a single connection, otherwise one
+// <ID>_DATABASE_URL per connection, matching what import --from dotenv reads.
+func exportDotenv(w io.Writer, ids []string, connections map[string]config.ConnectionParams) error {
+	for _, id := range ids {
+		key := "DATABASE_URL"
+		if len(ids) > 1 {
+			key = nonEnvChars.ReplaceAllString(strings.ToUpper(id), "_") + "_DATABASE_URL"
+		}
+		fmt.Fprintf(w, "%s=\"%s\"\n", key, database.FormatRemoteConnectionString(connections[id]))
+	}
+	return nil
+}
+
+func exportJSON(w io.Writer, ids []string, connections map[string]config.ConnectionParams) error {
+	data, err := json.MarshalIndent(connections, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
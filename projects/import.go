@@ -0,0 +1,476 @@
+package projects
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"proman/config"
+	"proman/database"
+	"proman/utils"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+type importedConnection struct {
+	id     string
+	params config.ConnectionParams
+	source string
+	action string
+}
+
+var importers = map[string]func(path string) ([]importedConnection, error){
+	"pgservice": importPGService,
+	"pgpass":    importPGPass,
+	"dotenv":    importDotenv,
+	"supabase":  importSupabase,
+}
+
+func Import(cfg *config.Config, configFile string, args []string) error {
+	var from string
+	var paths []string
+	onConflict := "skip"
+	dryRun, assumeYes := false, false
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--from":
+			if i+1 < len(args) {
+				from = args[i+1]
+				i++
+			} else {
+				return fmt.Errorf("--from flag requires a value")
+			}
+		case arg == "--on-conflict":
+			if i+1 < len(args) {
+				onConflict = args[i+1]
+				i++
+			} else {
+				return fmt.Errorf("--on-conflict flag requires a value")
+			}
+		case arg == "--dry-run":
+			dryRun = true
+		case arg == "--yes":
+			assumeYes = true
+		case !strings.HasPrefix(arg, "--"):
+			paths = append(paths, arg)
+		default:
+			return fmt.Errorf("unknown flag: %s", arg)
+		}
+	}
+
+	importer, found := importers[from]
+	if !found {
+		return fmt.Errorf("--from must be one of pgservice, pgpass, dotenv or supabase")
+	}
+	if onConflict != "skip" && onConflict != "overwrite" && onConflict != "rename" {
+		return fmt.Errorf("--on-conflict must be one of skip, overwrite or rename")
+	}
+
+	if len(paths) == 0 {
+		path, err := defaultImportPath(from)
+		if err != nil {
+			return err
+		}
+		paths = []string{path}
+	}
+
+	var imported []importedConnection
+	for _, path := range paths {
+		connections, err := importer(path)
+		if err != nil {
+			return fmt.Errorf("failed to import from %s: %w", path, err)
+		}
+		imported = append(imported, connections...)
+	}
+	if len(imported) == 0 {
+		utils.WarningPrint("No connections found to import\n")
+		return nil
+	}
+
+	planImport(cfg, imported, onConflict)
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tHOST\tUSER\tDATABASE\tSOURCE\tACTION")
+	fmt.Fprintln(w, "--\t----\t----\t--------\t------\t------")
+	for _, c := range imported {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.id, c.params.Host, c.params.User, c.params.DBName, c.source, c.action)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	if !assumeYes {
+		response, err := utils.Prompt(reader, "Import these connections? (y/n): ")
+		if err != nil {
+			return fmt.Errorf("failed to read user input: %w", err)
+		}
+		if strings.TrimSpace(strings.ToLower(response)) != "y" {
+			utils.ErrorPrint("Import cancelled by user\n")
+			return nil
+		}
+	}
+
+	for i := range imported {
+		c := &imported[i]
+		if c.action == "skip" || c.params.Password != "" || assumeYes {
+			continue
+		}
+		password, err := utils.PromptSecret(reader, fmt.Sprintf("Password for %s (leave empty to skip): ", c.id))
+		if err != nil {
+			return err
+		}
+		c.params.Password = password
+	}
+
+	unlock, err := cfg.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	count := 0
+	for _, c := range imported {
+		if c.action == "skip" {
+			continue
+		}
+		// another process may have registered the same ID since the preview
+		if _, found := cfg.GetConnection(c.id); found && c.action != "overwrite" {
+			utils.WarningPrint("Project '%s' was registered while importing, skipping it\n", c.id)
+			continue
+		}
+		cfg.AddConnection(c.id, c.params)
+		count++
+	}
+
+	if err := cfg.Save(configFile); err != nil {
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+
+	utils.SuccessPrint("Imported %d connection(s)\n", count)
+	return nil
+}
+
+// planImport decides what happens to each imported connection whose ID is
+// already registered, or repeated within the import itself.
+func planImport(cfg *config.Config, imported []importedConnection, onConflict string) {
+	taken := map[string]bool{}
+	for _, id := range cfg.ListConnections() {
+		taken[id] = true
+	}
+
+	for i := range imported {
+		c := &imported[i]
+		if !taken[c.id] {
+			c.action = "new"
+		} else {
+			switch onConflict {
+			case "overwrite":
+				c.action = "overwrite"
+			case "rename":
+				base := c.id
+				for n := 2; taken[c.id]; n++ {
+					c.id = fmt.Sprintf("%s-%d", base, n)
+				}
+				c.action = "new (renamed from " + base + ")"
+			default:
+				c.action = "skip"
+			}
+		}
+		taken[c.id] = true
+	}
+}
+
+func defaultImportPath(from string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	switch from {
+	case "pgservice":
+		if path := os.Getenv("PGSERVICEFILE"); path != "" {
+			return path, nil
+		}
+		return filepath.Join(home, ".pg_service.conf"), nil
+	case "pgpass":
+		if path := os.Getenv("PGPASSFILE"); path != "" {
+			return path, nil
+		}
+		return filepath.Join(home, ".pgpass"), nil
+	case "dotenv":
+		return ".env", nil
+	default:
+		return ".", nil
+	}
+}
+
+var nonIDChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// connectionID derives a readable ID for an imported connection: the project
+// ref for Supabase hosts, otherwise the first label of the host name.
+func connectionID(params config.ConnectionParams) string {
+	id := params.SupabaseProjectID
+	if id == "" {
+		id = strings.Split(params.Host, ".")[0]
+	}
+	if params.DBName != "" && params.DBName != "postgres" {
+		id += "_" + params.DBName
+	}
+	return strings.Trim(nonIDChars.ReplaceAllString(id, "-"), "-")
+}
+
+// supabaseRef extracts the project ref from a db.<ref>.supabase.co host.
+func supabaseRef(host string) string {
+	labels := strings.Split(strings.ToLower(host), ".")
+	if len(labels) == 4 && labels[0] == "db" && labels[2] == "supabase" && labels[3] == "co" {
+		return labels[1]
+	}
+	return ""
+}
+
+func withDefaults(params config.ConnectionParams) config.ConnectionParams {
+	if params.Port == "" {
+		params.Port = "5432"
+	}
+	if params.DBName == "" {
+		params.DBName = "postgres"
+	}
+	if params.SupabaseProjectID == "" {
+		params.SupabaseProjectID = supabaseRef(params.Host)
+	}
+	return params
+}
+
+func importPGService(path string) ([]importedConnection, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var imported []importedConnection
+	var current *importedConnection
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			imported = append(imported, importedConnection{
+				id:     strings.TrimSpace(line[1 : len(line)-1]),
+				source: path,
+			})
+			current = &imported[len(imported)-1]
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found || current == nil {
+			return nil, fmt.Errorf("line %d: expected a [service] header or key=value", lineNumber)
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "host", "hostaddr":
+			current.params.Host = value
+		case "port":
+			current.params.Port = value
+		case "user":
+			current.params.User = value
+		case "password":
+			current.params.Password = value
+		case "dbname":
+			current.params.DBName = value
+		case "sslmode":
+			current.params.SSLMode = value
+		case "sslrootcert":
+			current.params.SSLRootCert = value
+		case "sslcert":
+			current.params.SSLCert = value
+		case "sslkey":
+			current.params.SSLKey = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range imported {
+		imported[i].params = withDefaults(imported[i].params)
+	}
+	return imported, nil
+}
+
+// splitPGPassLine splits a .pgpass line on unescaped colons.
+func splitPGPassLine(line string) []string {
+	var fields []string
+	var field strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+		case line[i] == ':':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(line[i])
+		}
+	}
+	return append(fields, field.String())
+}
+
+func importPGPass(path string) ([]importedConnection, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var imported []importedConnection
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		// only the line ending is dropped, spaces can be part of a password
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if trimmed := strings.TrimSpace(line); trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		fields := splitPGPassLine(line)
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("line %d: expected host:port:database:user:password", lineNumber)
+		}
+		if fields[0] == "*" || fields[3] == "*" {
+			utils.WarningPrint("Skipping wildcard entry on line %d of %s\n", lineNumber, path)
+			continue
+		}
+
+		params := config.ConnectionParams{
+			Host:     fields[0],
+			Port:     fields[1],
+			DBName:   fields[2],
+			User:     fields[3],
+			Password: fields[4],
+		}
+		// a wildcard port or database falls back to the defaults
+		if params.Port == "*" {
+			params.Port = ""
+		}
+		if params.DBName == "*" {
+			params.DBName = ""
+		}
+		params = withDefaults(params)
+		imported = append(imported, importedConnection{id: connectionID(params), params: params, source: path})
+	}
+	return imported, scanner.Err()
+}
+
+func importDotenv(path string) ([]importedConnection, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	projectName := filepath.Base(filepath.Dir(absolute))
+
+	var imported []importedConnection
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+
+		if key != "DATABASE_URL" && !strings.HasSuffix(key, "_DATABASE_URL") {
+			continue
+		}
+
+		params, err := database.ParseConnectionString(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+
+		id := projectName
+		if prefix := strings.TrimSuffix(key, "_DATABASE_URL"); prefix != key {
+			id = strings.ToLower(prefix)
+		}
+		imported = append(imported, importedConnection{
+			id:     strings.Trim(nonIDChars.ReplaceAllString(id, "-"), "-"),
+			params: withDefaults(params),
+			source: path + ":" + key,
+		})
+	}
+	return imported, scanner.Err()
+}
+
+// importSupabase reads the project ref the Supabase CLI stores when a project
+// directory is linked. path is the project directory or the project-ref file.
+func importSupabase(path string) ([]importedConnection, error) {
+	refFile := path
+	projectDir := path
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		refFile = filepath.Join(path, "supabase", ".temp", "project-ref")
+	} else {
+		projectDir = filepath.Dir(filepath.Dir(filepath.Dir(path)))
+	}
+
+	data, err := os.ReadFile(refFile)
+	if err != nil {
+		return nil, err
+	}
+	ref := strings.TrimSpace(string(data))
+	if ref == "" {
+		return nil, fmt.Errorf("%s is empty", refFile)
+	}
+
+	absolute, err := filepath.Abs(projectDir)
+	if err != nil {
+		return nil, err
+	}
+
+	params := withDefaults(config.ConnectionParams{
+		Host:              fmt.Sprintf("db.%s.supabase.co", ref),
+		User:              "postgres",
+		SupabaseProjectID: ref,
+	})
+
+	// linked projects also record their Supavisor URL, which is session mode on
+	// 5432 and transaction mode on 6543
+	if data, err := os.ReadFile(filepath.Join(filepath.Dir(refFile), "pooler-url")); err == nil {
+		if pooler, err := parseEndpoint(strings.TrimSpace(string(data)), "5432"); err == nil {
+			if pooler.Port == "6543" {
+				params.TransactionPooler = &pooler
+			} else {
+				params.SessionPooler = &pooler
+			}
+		}
+	}
+	return []importedConnection{{
+		id:     strings.Trim(nonIDChars.ReplaceAllString(filepath.Base(absolute), "-"), "-"),
+		params: params,
+		source: refFile,
+	}}, nil
+}
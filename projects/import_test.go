@@ -0,0 +1,169 @@
+package projects
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"proman/config"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestSplitPGPassLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{`db.example.com:5432:postgres:postgres:secret`, []string{"db.example.com", "5432", "postgres", "postgres", "secret"}},
+		{`host:5432:db:user:pa\:ss`, []string{"host", "5432", "db", "user", "pa:ss"}},
+		{`host:5432:db:user:back\\slash`, []string{"host", "5432", "db", "user", `back\slash`}},
+		{`host:5432:db:user:ends\\`, []string{"host", "5432", "db", "user", `ends\`}},
+		{`host:5432:db:user:\\\:`, []string{"host", "5432", "db", "user", `\:`}},
+		{`host:5432:db:user:`, []string{"host", "5432", "db", "user", ""}},
+		{`host:5432:db:user:trailing\`, []string{"host", "5432", "db", "user", `trailing\`}},
+		{`host:5432:db:user:a:b`, []string{"host", "5432", "db", "user", "a", "b"}},
+	}
+	for _, tt := range tests {
+		if got := splitPGPassLine(tt.line); !slices.Equal(got, tt.want) {
+			t.Errorf("splitPGPassLine(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func writeTemp(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestImportPGPass(t *testing.T) {
+	path := writeTemp(t, ".pgpass", `# comment
+db.abcdefghijklmnopqrst.supabase.co:5432:postgres:postgres:secret
+*:5432:postgres:postgres:everywhere
+db.example.com:5432:postgres:*:anyone
+db.example.com:*:*:app:pw
+db.example.com:6543:my*db:app:pw
+
+`)
+	imported, err := importPGPass(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []importedConnection{
+		{id: "abcdefghijklmnopqrst", source: path, params: config.ConnectionParams{
+			Host: "db.abcdefghijklmnopqrst.supabase.co", Port: "5432", DBName: "postgres", User: "postgres", Password: "secret",
+			SupabaseProjectID: "abcdefghijklmnopqrst",
+		}},
+		{id: "db", source: path, params: config.ConnectionParams{
+			Host: "db.example.com", Port: "5432", DBName: "postgres", User: "app", Password: "pw",
+		}},
+		{id: "db_my-db", source: path, params: config.ConnectionParams{
+			Host: "db.example.com", Port: "6543", DBName: "my*db", User: "app", Password: "pw",
+		}},
+	}
+	if !reflect.DeepEqual(imported, want) {
+		t.Fatalf("got %+v\nwant %+v", imported, want)
+	}
+}
+
+func TestImportPGPassRejectsShortLines(t *testing.T) {
+	path := writeTemp(t, ".pgpass", "db.example.com:5432:postgres:postgres\n")
+	if _, err := importPGPass(path); err == nil {
+		t.Fatal("got no error for a line with four fields")
+	}
+}
+
+func TestPlanImport(t *testing.T) {
+	tests := []struct {
+		onConflict  string
+		wantIDs     []string
+		wantActions []string
+	}{
+		{"skip", []string{"prod", "prod", "dev"}, []string{"skip", "skip", "new"}},
+		{"overwrite", []string{"prod", "prod", "dev"}, []string{"overwrite", "overwrite", "new"}},
+		{"rename", []string{"prod-3", "prod-4", "dev"}, []string{"new (renamed from prod)", "new (renamed from prod)", "new"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.onConflict, func(t *testing.T) {
+			cfg, err := config.Load(filepath.Join(t.TempDir(), "config.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.AddConnection("prod", config.ConnectionParams{Host: "old.example.com"})
+			cfg.AddConnection("prod-2", config.ConnectionParams{Host: "other.example.com"})
+
+			imported := []importedConnection{{id: "prod"}, {id: "prod"}, {id: "dev"}}
+			planImport(cfg, imported, tt.onConflict)
+
+			for i, c := range imported {
+				if c.id != tt.wantIDs[i] || c.action != tt.wantActions[i] {
+					t.Errorf("connection %d: got %s (%s), want %s (%s)", i, c.id, c.action, tt.wantIDs[i], tt.wantActions[i])
+				}
+			}
+		})
+	}
+}
+
+// roundTripConnections have the characters each format has to escape or keep.
+var roundTripConnections = map[string]config.ConnectionParams{
+	"colon":     {Host: "db.example.com", Port: "5432", DBName: "postgres", User: "postgres", Password: `pa:ss`},
+	"backslash": {Host: "db.example.com", Port: "5432", DBName: "app", User: "app", Password: `back\slash\`},
+	"spaces":    {Host: "db.example.com", Port: "6543", DBName: "app", User: "app user", Password: ` padded `},
+	"hash":      {Host: "db.example.com", Port: "5432", DBName: "postgres", User: "reader", Password: `#\:*`},
+}
+
+func TestPGPassRoundTrip(t *testing.T) {
+	ids := []string{"backslash", "colon", "hash", "spaces"}
+	var exported bytes.Buffer
+	if err := exportPGPass(&exported, ids, roundTripConnections); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := importPGPass(writeTemp(t, ".pgpass", exported.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != len(ids) {
+		t.Fatalf("imported %d connections from\n%s", len(imported), exported.String())
+	}
+	for i, id := range ids {
+		if got, want := imported[i].params, withDefaults(roundTripConnections[id]); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", id, got, want)
+		}
+	}
+}
+
+func TestPGServiceRoundTrip(t *testing.T) {
+	ids := []string{"backslash", "colon", "hash"}
+	connections := map[string]config.ConnectionParams{}
+	for _, id := range ids {
+		params := roundTripConnections[id]
+		params.SSLMode = "verify-full"
+		params.SSLRootCert = "/certs/root.crt"
+		connections[id] = params
+	}
+
+	var exported bytes.Buffer
+	if err := exportPGService(&exported, ids, connections); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := importPGService(writeTemp(t, "pg_service.conf", exported.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != len(ids) {
+		t.Fatalf("imported %d connections from\n%s", len(imported), exported.String())
+	}
+	for i, id := range ids {
+		if imported[i].id != id {
+			t.Errorf("got ID %s, want %s", imported[i].id, id)
+		}
+		if got, want := imported[i].params, withDefaults(connections[id]); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", id, got, want)
+		}
+	}
+}
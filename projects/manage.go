@@ -3,74 +3,444 @@ package projects
 import (
 	"bufio"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"proman/config"
+	"proman/database"
 	"proman/utils"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/ugurcsen/gods-generic/sets/hashset"
+	"golang.org/x/term"
 )
 
-func Register(cfg *config.Config, configFile string, args []string) error {
-	if len(args) != 0 {
-		return fmt.Errorf("register command takes no arguments")
+// connectionEnv maps the PROMAN_* environment variables accepted by register
+// to the connection field they set.
+var connectionEnv = []struct {
+	name string
+	flag string
+}{
+	{"PROMAN_URL", "--url"},
+	{"PROMAN_HOST", "--host"},
+	{"PROMAN_PORT", "--port"},
+	{"PROMAN_USER", "--user"},
+	{"PROMAN_PASSWORD", "--password"},
+	{"PROMAN_DB", "--db"},
+	{"PROMAN_SUPABASE_REF", "--supabase-ref"},
+	{"PROMAN_SSLMODE", "--sslmode"},
+	{"PROMAN_SSLROOTCERT", "--sslrootcert"},
+	{"PROMAN_SSLCERT", "--sslcert"},
+	{"PROMAN_SSLKEY", "--sslkey"},
+	{"PROMAN_TAGS", "--tag"},
+	{"PROMAN_SESSION_POOLER", "--session-pooler"},
+	{"PROMAN_TRANSACTION_POOLER", "--transaction-pooler"},
+}
+
+// applyConnectionFlag sets the field named by flag. A --url replaces every field
+// it contains, so individual flags given after it still take precedence.
+func applyConnectionFlag(params *config.ConnectionParams, flag, value string) error {
+	switch flag {
+	case "--url":
+		parsed, err := database.ParseConnectionString(value)
+		if err != nil {
+			return err
+		}
+		mergeConnection(params, parsed)
+	case "--host":
+		params.Host = value
+	case "--port":
+		params.Port = value
+	case "--user":
+		params.User = value
+	case "--password":
+		params.Password = value
+	case "--password-env":
+		params.PasswordEnv = value
+	case "--password-command":
+		params.PasswordCommand = value
+	case "--db":
+		params.DBName = value
+	case "--supabase-ref":
+		params.SupabaseProjectID = value
+	case "--sslmode":
+		switch value {
+		case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+			params.SSLMode = value
+		default:
+			return fmt.Errorf("invalid sslmode '%s'", value)
+		}
+	case "--tag":
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" && !params.HasTag(tag) {
+				params.Tags = append(params.Tags, tag)
+			}
+		}
+	case "--ssh-host", "--ssh-port", "--ssh-user", "--ssh-key", "--ssh-known-hosts":
+		if params.SSH == nil {
+			params.SSH = &config.SSHTunnel{}
+		}
+		switch flag {
+		case "--ssh-host":
+			params.SSH.Host = value
+		case "--ssh-port":
+			params.SSH.Port = value
+		case "--ssh-user":
+			params.SSH.User = value
+		case "--ssh-key":
+			params.SSH.KeyPath = value
+		case "--ssh-known-hosts":
+			params.SSH.KnownHosts = value
+		}
+	case "--session-pooler":
+		pooler, err := parseEndpoint(value, "5432")
+		if err != nil {
+			return err
+		}
+		params.SessionPooler = &pooler
+	case "--transaction-pooler":
+		pooler, err := parseEndpoint(value, "6543")
+		if err != nil {
+			return err
+		}
+		params.TransactionPooler = &pooler
+	case "--keep-last", "--keep-daily", "--keep-weekly", "--keep-monthly":
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 0 {
+			return fmt.Errorf("%s expects a number of backups, got '%s'", flag, value)
+		}
+		if params.Retention == nil {
+			params.Retention = &config.RetentionPolicy{}
+		}
+		switch flag {
+		case "--keep-last":
+			params.Retention.KeepLast = count
+		case "--keep-daily":
+			params.Retention.KeepDaily = count
+		case "--keep-weekly":
+			params.Retention.KeepWeekly = count
+		case "--keep-monthly":
+			params.Retention.KeepMonthly = count
+		}
+	case "--compress":
+		compression, err := database.ParseCompression(value, 0)
+		if err != nil {
+			return err
+		}
+		params.Compress = compression.Method
+	case "--format":
+		format, err := database.ParseFormat(value)
+		if err != nil {
+			return err
+		}
+		params.Format = format
+	case "--jobs":
+		jobs, err := strconv.Atoi(value)
+		if err != nil || jobs < 1 {
+			return fmt.Errorf("--jobs expects a positive number, got '%s'", value)
+		}
+		params.Jobs = jobs
+	case "--recipient":
+		for _, recipient := range strings.Split(value, ",") {
+			recipient = strings.TrimSpace(recipient)
+			if _, err := database.ParseRecipient(recipient); err != nil {
+				return err
+			}
+			if !slices.Contains(params.Recipients, recipient) {
+				params.Recipients = append(params.Recipients, recipient)
+			}
+		}
+	case "--compress-level":
+		level, err := strconv.Atoi(value)
+		if err != nil || level < 1 {
+			return fmt.Errorf("--compress-level expects a positive number, got '%s'", value)
+		}
+		params.CompressLevel = level
+	case "--sslrootcert":
+		params.SSLRootCert = value
+	case "--sslcert":
+		params.SSLCert = value
+	case "--sslkey":
+		params.SSLKey = value
+	default:
+		return fmt.Errorf("unknown flag: %s", flag)
 	}
+	return nil
+}
 
-	reader := bufio.NewReader(os.Stdin)
-	utils.InfoPrint("Registering a new Supabase project connection\n")
+// parseEndpoint reads a pooler endpoint given as host[:port] or as a full
+// postgres:// URI, whose user is kept if present.
+func parseEndpoint(value, defaultPort string) (config.Endpoint, error) {
+	if strings.Contains(value, "://") {
+		parsed, err := database.ParseConnectionString(value)
+		if err != nil {
+			return config.Endpoint{}, err
+		}
+		if parsed.Port == "" {
+			parsed.Port = defaultPort
+		}
+		return config.Endpoint{Host: parsed.Host, Port: parsed.Port, User: parsed.User}, nil
+	}
+
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		host, port = value, defaultPort
+	}
+	if host == "" {
+		return config.Endpoint{}, fmt.Errorf("invalid pooler endpoint '%s'", value)
+	}
+	return config.Endpoint{Host: host, Port: port}, nil
+}
 
-	projectID, err := utils.Prompt(reader, "Enter a unique ID for this project: ")
+func mergeConnection(params *config.ConnectionParams, override config.ConnectionParams) {
+	if override.Host != "" {
+		params.Host = override.Host
+	}
+	if override.Port != "" {
+		params.Port = override.Port
+	}
+	if override.User != "" {
+		params.User = override.User
+	}
+	if override.Password != "" {
+		params.Password = override.Password
+	}
+	if override.PasswordEnv != "" {
+		params.PasswordEnv = override.PasswordEnv
+	}
+	if override.PasswordCommand != "" {
+		params.PasswordCommand = override.PasswordCommand
+	}
+	if override.DBName != "" {
+		params.DBName = override.DBName
+	}
+	if override.SupabaseProjectID != "" {
+		params.SupabaseProjectID = override.SupabaseProjectID
+	}
+	if override.SSLMode != "" {
+		params.SSLMode = override.SSLMode
+	}
+	if override.SSLRootCert != "" {
+		params.SSLRootCert = override.SSLRootCert
+	}
+	if override.SSLCert != "" {
+		params.SSLCert = override.SSLCert
+	}
+	if override.SSLKey != "" {
+		params.SSLKey = override.SSLKey
+	}
+	if override.Protected {
+		params.Protected = true
+	}
+	if override.SessionPooler != nil {
+		params.SessionPooler = override.SessionPooler
+	}
+	if override.TransactionPooler != nil {
+		params.TransactionPooler = override.TransactionPooler
+	}
+	if override.SSH != nil {
+		if params.SSH == nil {
+			params.SSH = &config.SSHTunnel{}
+		}
+		if override.SSH.Host != "" {
+			params.SSH.Host = override.SSH.Host
+		}
+		if override.SSH.Port != "" {
+			params.SSH.Port = override.SSH.Port
+		}
+		if override.SSH.User != "" {
+			params.SSH.User = override.SSH.User
+		}
+		if override.SSH.KeyPath != "" {
+			params.SSH.KeyPath = override.SSH.KeyPath
+		}
+		if override.SSH.KnownHosts != "" {
+			params.SSH.KnownHosts = override.SSH.KnownHosts
+		}
+	}
+	if override.Retention != nil {
+		params.Retention = override.Retention
+	}
+	if override.Compress != "" {
+		params.Compress = override.Compress
+	}
+	if override.CompressLevel != 0 {
+		params.CompressLevel = override.CompressLevel
+	}
+	if override.Format != "" {
+		params.Format = override.Format
+	}
+	if override.Jobs != 0 {
+		params.Jobs = override.Jobs
+	}
+	for _, recipient := range override.Recipients {
+		if !slices.Contains(params.Recipients, recipient) {
+			params.Recipients = append(params.Recipients, recipient)
+		}
+	}
+	for _, tag := range override.Tags {
+		if !params.HasTag(tag) {
+			params.Tags = append(params.Tags, tag)
+		}
+	}
+}
+
+// registerArgs reads the connection given to register through PROMAN_*
+// variables and flags, flags taking precedence. interactive is set when neither
+// gave anything.
+func registerArgs(args []string) (projectID string, params config.ConnectionParams, interactive bool, err error) {
+	interactive = len(args) == 0
+
+	for _, env := range connectionEnv {
+		if value := os.Getenv(env.name); value != "" {
+			if err := applyConnectionFlag(&params, env.flag, value); err != nil {
+				return "", params, false, fmt.Errorf("invalid %s: %w", env.name, err)
+			}
+			interactive = false
+		}
+	}
+
+	flagParams := config.ConnectionParams{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--protected" {
+			flagParams.Protected = true
+			continue
+		}
+		if !strings.HasPrefix(arg, "--") {
+			if projectID != "" {
+				return "", params, false, fmt.Errorf("register command takes a single project ID, got '%s' and '%s'", projectID, arg)
+			}
+			projectID = arg
+			continue
+		}
+		if i+1 >= len(args) {
+			return "", params, false, fmt.Errorf("%s flag requires a value", arg)
+		}
+		if err := applyConnectionFlag(&flagParams, arg, args[i+1]); err != nil {
+			return "", params, false, err
+		}
+		i++
+	}
+	mergeConnection(&params, flagParams)
+	return projectID, params, interactive, nil
+}
+
+func Register(cfg *config.Config, configFile string, args []string) error {
+	projectID, params, interactive, err := registerArgs(args)
 	if err != nil {
 		return err
 	}
+
+	reader := bufio.NewReader(os.Stdin)
+	if interactive {
+		utils.InfoPrint("Registering a new Supabase project connection\n")
+	}
+
+	if projectID == "" {
+		projectID, err = utils.Prompt(reader, "Enter a unique ID for this project: ")
+		if err != nil {
+			return err
+		}
+	}
+	if projectID == "" {
+		return fmt.Errorf("project ID cannot be empty")
+	}
 	if _, found := cfg.GetConnection(projectID); found {
 		return fmt.Errorf("project with ID '%s' already exists", projectID)
 	}
 
-	host, err := utils.Prompt(reader, "Enter Host: ")
-	if err != nil {
-		return err
+	hasPooler := params.SessionPooler != nil || params.TransactionPooler != nil
+	if params.Host == "" && (interactive || !hasPooler) {
+		params.Host, err = utils.Prompt(reader, "Enter Host: ")
+		if err != nil {
+			return err
+		}
 	}
 
-	port, err := utils.Prompt(reader, "Enter Port (default: 5432): ")
-	if err != nil {
-		return err
+	if params.Port == "" && interactive {
+		params.Port, err = utils.Prompt(reader, "Enter Port (default: 5432): ")
+		if err != nil {
+			return err
+		}
 	}
-	if port == "" {
-		port = "5432"
+	if params.Port == "" {
+		params.Port = "5432"
 	}
 
-	user, err := utils.Prompt(reader, "Enter User: ")
-	if err != nil {
-		return err
+	if params.User == "" {
+		params.User, err = utils.Prompt(reader, "Enter User: ")
+		if err != nil {
+			return err
+		}
 	}
 
-	password, err := utils.Prompt(reader, "Enter Password: ")
-	if err != nil {
-		return err
+	if params.Password == "" && params.PasswordEnv == "" && params.PasswordCommand == "" {
+		if !interactive && !term.IsTerminal(int(os.Stdin.Fd())) {
+			return fmt.Errorf("no password given: pass --password-env, --password-command or include it in the URL")
+		}
+		params.Password, err = utils.PromptSecret(reader, "Enter Password: ")
+		if err != nil {
+			return err
+		}
 	}
 
-	dbName, err := utils.Prompt(reader, "Enter Database Name (default: postgres): ")
-	if err != nil {
-		return err
+	if params.DBName == "" && interactive {
+		params.DBName, err = utils.Prompt(reader, "Enter Database Name (default: postgres): ")
+		if err != nil {
+			return err
+		}
+	}
+	if params.DBName == "" {
+		params.DBName = "postgres"
+	}
+
+	if params.SupabaseProjectID == "" && interactive {
+		params.SupabaseProjectID, err = utils.Prompt(reader, "Enter Supabase Project ID: ")
+		if err != nil {
+			return err
+		}
 	}
-	if dbName == "" {
-		dbName = "postgres"
+
+	if rootCert, missing := database.MissingRootCert(params); missing {
+		if interactive {
+			utils.InfoPrint("sslmode %s verifies the server, which needs its CA certificate\n", database.SSLMode(params))
+			params.SSLRootCert, err = promptRootCert(reader)
+			if err != nil {
+				return err
+			}
+		} else {
+			utils.WarningPrint(
+				"sslmode %s verifies the server, but no --sslrootcert was given and %s doesn't exist. "+
+					"Connecting will fail until sslrootcert is set in the config\n",
+				database.SSLMode(params), rootCert,
+			)
+		}
 	}
 
-	supabaseProjectID, err := utils.Prompt(reader, "Enter Supabase Project ID: ")
+	if params.Host == "" && params.SessionPooler == nil && params.TransactionPooler != nil {
+		utils.WarningPrint("Only a transaction pooler is configured. Dumps and migrations need a direct host or session pooler\n")
+	}
+
+	if params.SSH != nil && params.SSH.Host == "" {
+		return fmt.Errorf("--ssh-host is required when any other --ssh-* flag is given")
+	}
+
+	unlock, err := cfg.Lock()
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	params := config.ConnectionParams{
-		Password:          password,
-		Host:              host,
-		Port:              port,
-		User:              user,
-		DBName:            dbName,
-		SupabaseProjectID: supabaseProjectID,
+	// another process may have registered the same ID while we were prompting
+	if _, found := cfg.GetConnection(projectID); found {
+		return fmt.Errorf("project with ID '%s' already exists", projectID)
 	}
 	cfg.AddConnection(projectID, params)
 
@@ -82,12 +452,37 @@ func Register(cfg *config.Config, configFile string, args []string) error {
 	return nil
 }
 
+// connectionInfo is what `connection list` reports for --output. The password
+// itself is never included, only where it comes from.
+type connectionInfo struct {
+	ID string `json:"id"`
+	config.ConnectionParams
+	PasswordSource string `json:"password_source"`
+	LastBackup     string `json:"last_backup"`
+}
+
 func List(cfg *config.Config, args []string) error {
 	if len(args) != 0 {
 		return fmt.Errorf("list command takes no arguments")
 	}
 
 	connectionIDs := cfg.ListConnections()
+	sort.Strings(connectionIDs)
+
+	if utils.MachineOutput() {
+		infos := make([]connectionInfo, 0, len(connectionIDs))
+		for _, id := range connectionIDs {
+			params, _ := cfg.GetConnection(id)
+			info := connectionInfo{ID: id, ConnectionParams: params, PasswordSource: cfg.PasswordSource(id, params)}
+			info.Password = ""
+			if at, found := cfg.LastBackup(id); found {
+				info.LastBackup = at.Format(time.RFC3339)
+			}
+			infos = append(infos, info)
+		}
+		return utils.WriteOutput(infos)
+	}
+
 	if len(connectionIDs) == 0 {
 		utils.WarningPrint("No projects are registered yet. Use 'proman register' to add one")
 		return nil
@@ -96,12 +491,20 @@ func List(cfg *config.Config, args []string) error {
 	w := new(tabwriter.Writer)
 	w.Init(os.Stdout, 0, 8, 2, ' ', 0)
 
-	fmt.Fprintln(w, "ID\tHOST\tUSER\tDATABASE")
-	fmt.Fprintln(w, "--\t----\t----\t--------")
+	fmt.Fprintln(w, "ID\tHOST\tUSER\tDATABASE\tTAGS\tPROTECTED\tLAST BACKUP")
+	fmt.Fprintln(w, "--\t----\t----\t--------\t----\t---------\t-----------")
 
 	for _, id := range connectionIDs {
 		params, _ := cfg.GetConnection(id)
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", id, params.Host, params.User, params.DBName)
+		protected := ""
+		if params.Protected {
+			protected = "yes"
+		}
+		lastBackup := ""
+		if at, found := cfg.LastBackup(id); found {
+			lastBackup = at.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", id, params.Host, params.User, params.DBName, strings.Join(params.Tags, ","), protected, lastBackup)
 	}
 
 	return w.Flush()
@@ -113,6 +516,12 @@ func Remove(cfg *config.Config, configFile string, args []string) error {
 	}
 	projectID := args[0]
 
+	unlock, err := cfg.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	if _, found := cfg.GetConnection(projectID); !found {
 		return fmt.Errorf("project with ID '%s' not found", projectID)
 	}
@@ -127,6 +536,185 @@ func Remove(cfg *config.Config, configFile string, args []string) error {
 	return nil
 }
 
+func Tag(cfg *config.Config, configFile string, args []string) error {
+	var projectID string
+	var tags []string
+	remove := false
+
+	for _, arg := range args {
+		switch {
+		case arg == "--remove":
+			remove = true
+		case strings.HasPrefix(arg, "--"):
+			return fmt.Errorf("unknown flag: %s", arg)
+		case projectID == "":
+			projectID = arg
+		default:
+			tags = append(tags, arg)
+		}
+	}
+
+	if projectID == "" || len(tags) == 0 {
+		return fmt.Errorf("tag command expects a project ID followed by one or more tags")
+	}
+
+	unlock, err := cfg.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	params, found := cfg.GetConnection(projectID)
+	if !found {
+		return fmt.Errorf("project with ID '%s' not found", projectID)
+	}
+
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, "@") || strings.ContainsAny(tag, ", ") {
+			return fmt.Errorf("invalid tag '%s': tags can't start with '@' or contain commas or spaces", tag)
+		}
+		if remove {
+			kept := params.Tags[:0]
+			for _, t := range params.Tags {
+				if t != tag {
+					kept = append(kept, t)
+				}
+			}
+			params.Tags = kept
+		} else if !params.HasTag(tag) {
+			params.Tags = append(params.Tags, tag)
+		}
+	}
+	cfg.AddConnection(projectID, params)
+
+	if err := cfg.Save(configFile); err != nil {
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+
+	utils.SuccessPrint("Tags for %s: %s\n", projectID, strings.Join(params.Tags, ", "))
+	return nil
+}
+
+func Protect(cfg *config.Config, configFile string, args []string) error {
+	var projectID string
+	protected, override := true, false
+
+	for _, arg := range args {
+		switch {
+		case arg == "--off":
+			protected = false
+		case arg == database.OverrideProtectionFlag:
+			override = true
+		case strings.HasPrefix(arg, "--"):
+			return fmt.Errorf("unknown flag: %s", arg)
+		case projectID == "":
+			projectID = arg
+		default:
+			return fmt.Errorf("protect command expects exactly one project ID")
+		}
+	}
+	if projectID == "" {
+		return fmt.Errorf("protect command expects exactly one project ID")
+	}
+
+	// lifting the protection takes the same confirmation as writing
+	if params, found := cfg.GetConnection(projectID); found && params.Protected && !protected {
+		if err := database.ConfirmProtected(projectID, "This removes its protection", override); err != nil {
+			return err
+		}
+	}
+
+	unlock, err := cfg.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	params, found := cfg.GetConnection(projectID)
+	if !found {
+		return fmt.Errorf("project with ID '%s' not found", projectID)
+	}
+	params.Protected = protected
+	cfg.AddConnection(projectID, params)
+
+	if err := cfg.Save(configFile); err != nil {
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+
+	if protected {
+		utils.SuccessPrint("Project %s is now protected\n", projectID)
+	} else {
+		utils.WarningPrint("Project %s is no longer protected\n", projectID)
+	}
+	return nil
+}
+
+func Retention(cfg *config.Config, configFile string, args []string) error {
+	var projectID string
+	var rules [][2]string
+	off := false
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--off":
+			off = true
+		case strings.HasPrefix(arg, "--keep-"):
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s flag requires a value", arg)
+			}
+			rules = append(rules, [2]string{arg, args[i+1]})
+			i++
+		case strings.HasPrefix(arg, "--"):
+			return fmt.Errorf("unknown flag: %s", arg)
+		case projectID == "":
+			projectID = arg
+		default:
+			return fmt.Errorf("retention command expects exactly one project ID")
+		}
+	}
+	if projectID == "" {
+		return fmt.Errorf("retention command expects exactly one project ID")
+	}
+	if !off && len(rules) == 0 {
+		return fmt.Errorf("retention command expects --keep-last, --keep-daily, --keep-weekly, --keep-monthly or --off")
+	}
+
+	unlock, err := cfg.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	params, found := cfg.GetConnection(projectID)
+	if !found {
+		return fmt.Errorf("project with ID '%s' not found", projectID)
+	}
+	if off {
+		params.Retention = nil
+	}
+	// only the rules given change, the others are kept
+	for _, rule := range rules {
+		if err := applyConnectionFlag(&params, rule[0], rule[1]); err != nil {
+			return err
+		}
+	}
+	cfg.AddConnection(projectID, params)
+
+	if err := cfg.Save(configFile); err != nil {
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+
+	if params.Retention == nil || params.Retention.IsZero() {
+		utils.WarningPrint("Project %s has no retention policy; prune will keep all of its backups\n", projectID)
+		return nil
+	}
+	r := params.Retention
+	utils.SuccessPrint("Project %s keeps the last %d, plus %d daily, %d weekly and %d monthly backups\n",
+		projectID, r.KeepLast, r.KeepDaily, r.KeepWeekly, r.KeepMonthly)
+	return nil
+}
+
 func Login(cfg *config.Config, args []string) error {
 	if len(args) != 0 {
 		return fmt.Errorf("login command takes no arguments")
@@ -150,28 +738,193 @@ func Login(cfg *config.Config, args []string) error {
 	return nil
 }
 
+// chooseBinary offers the detected installations of tool and returns the one
+// picked, a path typed by the user, or current when the answer is empty. A
+// path that isn't a working executable of tool is refused, current included.
+func chooseBinary(reader *bufio.Reader, tool, current string) (string, error) {
+	found := utils.DiscoverBinaries(tool)
+	if len(found) == 0 {
+		utils.WarningPrint("\nNo %s installation was found on PATH or in the usual locations\n", tool)
+	} else {
+		utils.InfoPrint("\nFound %s:\n", tool)
+		for i, binary := range found {
+			utils.InfoPrint("  %d) %s (%s)\n", i+1, binary.Path, binary.Version)
+		}
+	}
+
+	prompt := fmt.Sprintf("Choose %s by number or enter a path (current: %s): ", tool, current)
+	if len(found) == 0 {
+		prompt = fmt.Sprintf("Path to %s (current: %s): ", tool, current)
+	}
+
+	for {
+		answer, err := utils.Prompt(reader, prompt)
+		if err != nil {
+			return "", err
+		}
+		if answer == "" {
+			if current == "" {
+				return current, nil
+			}
+			if _, err := utils.BinaryVersion(tool, current); err != nil {
+				utils.ErrorPrint("The current %s can't be kept: %v\n", tool, err)
+				continue
+			}
+			return current, nil
+		}
+
+		var choice int
+		if _, err := fmt.Sscan(answer, &choice); err == nil && fmt.Sprint(choice) == answer {
+			if choice < 1 || choice > len(found) {
+				utils.ErrorPrint("Please choose a number between 1 and %d\n", len(found))
+				continue
+			}
+			return found[choice-1].Path, nil
+		}
+
+		version, err := utils.BinaryVersion(tool, answer)
+		if err != nil {
+			utils.ErrorPrint("%v\n", err)
+			continue
+		}
+		utils.InfoPrint("Using %s %s\n", tool, version)
+		return answer, nil
+	}
+}
+
+// promptRootCert asks for the CA certificate used to verify a server. An empty
+// answer leaves it to libpq's default location.
+func promptRootCert(reader *bufio.Reader) (string, error) {
+	for {
+		answer, err := utils.Prompt(reader, "Path to the CA certificate (Supabase's is under the project's database settings, empty to skip): ")
+		if err != nil {
+			return "", err
+		}
+		if answer == "" {
+			return "", nil
+		}
+		if !fileExists(answer) {
+			utils.ErrorPrint("%s doesn't exist\n", answer)
+			continue
+		}
+		return filepath.Abs(answer)
+	}
+}
+
+// chooseRootCert finds the Supabase-hosted connections that verify the server
+// but have no CA certificate to do it with, and asks for Supabase's.
+func chooseRootCert(reader *bufio.Reader, cfg *config.Config) (string, error) {
+	var ids []string
+	for _, id := range cfg.ListConnections() {
+		params, _ := cfg.GetConnection(id)
+		if _, missing := database.MissingRootCert(params); missing && database.IsSupabaseHost(params.Host) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return "", nil
+	}
+	sort.Strings(ids)
+
+	utils.InfoPrint("\n%s verify the server's certificate but have no CA certificate to do it with\n", strings.Join(ids, ", "))
+	return promptRootCert(reader)
+}
+
+// chooseVersions offers to register every detected PostgreSQL installation by
+// major version, so backups can use the pg_dump that matches each server. The
+// result is current with the detected versions added or replaced.
+func chooseVersions(reader *bufio.Reader, current map[string]config.PGTools) (map[string]config.PGTools, error) {
+	detected := map[string]config.PGTools{}
+	for _, binary := range utils.DiscoverBinaries("pg_dump") {
+		major := strings.Split(binary.Version, ".")[0]
+		if _, found := detected[major]; found {
+			continue
+		}
+		dir, ext := filepath.Dir(binary.Path), filepath.Ext(binary.Path)
+		tools := config.PGTools{PGDump: binary.Path}
+		if sibling := filepath.Join(dir, "pg_dumpall"+ext); fileExists(sibling) {
+			tools.PGDumpAll = sibling
+		}
+		if sibling := filepath.Join(dir, "psql"+ext); fileExists(sibling) {
+			tools.PSQL = sibling
+		}
+		if sibling := filepath.Join(dir, "pg_restore"+ext); fileExists(sibling) {
+			tools.PGRestore = sibling
+		}
+		detected[major] = tools
+	}
+	if len(detected) < 2 {
+		return current, nil
+	}
+
+	majors := make([]string, 0, len(detected))
+	for major := range detected {
+		majors = append(majors, major)
+	}
+	sort.Strings(majors)
+
+	utils.InfoPrint("\nFound PostgreSQL %s. proman can pick the pg_dump matching each server's version\n", strings.Join(majors, ", "))
+	for _, major := range majors {
+		if existing, found := current[major]; found && existing != detected[major] {
+			utils.WarningPrint("This replaces the configured PostgreSQL %s tools (pg_dump %s)\n", major, existing.PGDump)
+		}
+	}
+	answer, err := utils.Prompt(reader, "Register these installations by major version? (y/n): ")
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(strings.ToLower(answer)) != "y" {
+		return current, nil
+	}
+
+	// versions configured by hand that weren't found again are kept
+	merged := make(map[string]config.PGTools, len(current)+len(detected))
+	for major, tools := range current {
+		merged[major] = tools
+	}
+	for major, tools := range detected {
+		merged[major] = tools
+	}
+	return merged, nil
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
 func Init(cfg *config.Config, configFile string) error {
 	reader := bufio.NewReader(os.Stdin)
 	utils.InfoPrint("--- Configure Binary Paths ---\n")
-	utils.InfoPrint("Please provide the absolute paths for the following tools\n")
+	utils.InfoPrint("Pick one of the detected installations, or enter the path of a tool\n")
 	utils.InfoPrint("If a tool is already in your system's PATH, you can just enter its name (ex 'psql')\n")
 
-	psqlPath, err := utils.Prompt(reader, fmt.Sprintf("Path to psql (current: %s): ", cfg.Binaries.PSQL))
+	psqlPath, err := chooseBinary(reader, "psql", cfg.Binaries.PSQL)
 	if err != nil {
 		return err
 	}
 
-	pgDumpPath, err := utils.Prompt(reader, fmt.Sprintf("Path to pg_dump (current: %s): ", cfg.Binaries.PGDump))
+	pgDumpPath, err := chooseBinary(reader, "pg_dump", cfg.Binaries.PGDump)
 	if err != nil {
 		return err
 	}
 
-	pgDumpAllPath, err := utils.Prompt(reader, fmt.Sprintf("Path to pg_dumpall (current: %s): ", cfg.Binaries.PGDumpAll))
+	pgDumpAllPath, err := chooseBinary(reader, "pg_dumpall", cfg.Binaries.PGDumpAll)
 	if err != nil {
 		return err
 	}
 
-	supabasePath, err := utils.Prompt(reader, fmt.Sprintf("Path to supabase (current: %s): ", cfg.Binaries.Supabase))
+	supabasePath, err := chooseBinary(reader, "supabase", cfg.Binaries.Supabase)
+	if err != nil {
+		return err
+	}
+
+	versions, err := chooseVersions(reader, cfg.Binaries.Versions)
+	if err != nil {
+		return err
+	}
+
+	rootCert, err := chooseRootCert(reader, cfg)
 	if err != nil {
 		return err
 	}
@@ -188,6 +941,12 @@ func Init(cfg *config.Config, configFile string) error {
 		return err
 	}
 
+	unlock, err := cfg.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	if psqlPath != "" {
 		cfg.Binaries.PSQL = psqlPath
 	}
@@ -203,6 +962,16 @@ func Init(cfg *config.Config, configFile string) error {
 	if supabasePath != "" {
 		cfg.Binaries.Supabase = supabasePath
 	}
+	cfg.Binaries.Versions = versions
+	if rootCert != "" {
+		for _, id := range cfg.ListConnections() {
+			params, _ := cfg.GetConnection(id)
+			if _, missing := database.MissingRootCert(params); missing && database.IsSupabaseHost(params.Host) {
+				params.SSLRootCert = rootCert
+				cfg.AddConnection(id, params)
+			}
+		}
+	}
 
 	if err := cfg.Save(configFile); err != nil {
 		return fmt.Errorf("failed to save configuration: %w", err)
@@ -0,0 +1,157 @@
+package projects
+
+import (
+	"fmt"
+	"os"
+	"proman/config"
+	"proman/database"
+	"proman/utils"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+func Test(cfg *config.Config, args []string) error {
+	var selectors, tags []string
+	testAll := false
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--all":
+			testAll = true
+		case arg == "--tag":
+			if i+1 < len(args) {
+				tags = append(tags, args[i+1])
+				i++
+			} else {
+				return fmt.Errorf("--tag flag requires a value")
+			}
+		case !strings.HasPrefix(arg, "--"):
+			selectors = append(selectors, arg)
+		default:
+			return fmt.Errorf("unknown flag: %s", arg)
+		}
+	}
+
+	var projectIDs []string
+	if testAll {
+		projectIDs = cfg.ListConnections()
+		sort.Strings(projectIDs)
+	} else {
+		var err error
+		projectIDs, err = cfg.ResolveTargets(selectors, tags)
+		if err != nil {
+			return err
+		}
+	}
+	if len(projectIDs) == 0 {
+		return fmt.Errorf("test command requires a project ID, @group, --tag or --all")
+	}
+
+	connections := make([]config.ConnectionParams, len(projectIDs))
+	results := make([]database.ConnectionStatus, len(projectIDs))
+	prepared := make([]bool, len(projectIDs))
+	tunneled := make([]bool, len(projectIDs))
+	for i, id := range projectIDs {
+		params, found := cfg.GetConnection(id)
+		if !found {
+			return fmt.Errorf("project with ID '%s' not found", id)
+		}
+		tunneled[i] = params.SSH != nil && params.SSH.Host != ""
+		// prepare up front so a vault passphrase prompt doesn't race the probes.
+		// A connection that can't be prepared is reported like a failed probe
+		params, err := database.PrepareConnection(cfg, id, params, database.OpProbe)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		connections[i] = params
+		prepared[i] = true
+	}
+
+	spin := utils.NewSpinner("Testing %d connection(s)", len(projectIDs))
+	spin.Start()
+
+	var wg sync.WaitGroup
+	for i := range connections {
+		if !prepared[i] {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = database.CheckConnection(connections[i], cfg.GetBinaryPaths())
+		}(i)
+	}
+	wg.Wait()
+	spin.Stop()
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(w, "ID\tREACHABLE\tAUTH\tLATENCY\tVERSION\tTLS\tEXTENSIONS\tERROR")
+	fmt.Fprintln(w, "--\t---------\t----\t-------\t-------\t---\t----------\t-----")
+
+	failed := 0
+	anyTunneled := false
+	for i, id := range projectIDs {
+		status := results[i]
+		if !status.OK() {
+			failed++
+		}
+
+		latency := "-"
+		if status.Reachable {
+			latency = status.Latency.Round(time.Millisecond).String()
+			if tunneled[i] {
+				latency += " (tunnel)"
+				anyTunneled = true
+			}
+		}
+		errMessage := ""
+		if status.Err != nil {
+			errMessage = status.Err.Error()
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			id,
+			yesNo(status.Reachable),
+			yesNo(status.Authorized),
+			latency,
+			orDash(status.Version),
+			yesNo(status.TLS),
+			orDash(strings.Join(status.Extensions, ",")),
+			errMessage,
+		)
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if anyTunneled {
+		utils.InfoPrint("\nLatency marked (tunnel) is measured to the SSH tunnel's local end at 127.0.0.1, not to the database\n")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d connection(s) failed", failed, len(projectIDs))
+	}
+	utils.SuccessPrint("All connections are healthy\n")
+	return nil
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
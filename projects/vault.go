@@ -0,0 +1,54 @@
+package projects
+
+import (
+	"fmt"
+	"proman/config"
+	"proman/utils"
+)
+
+func VaultMigrate(cfg *config.Config, configFile string, args []string) error {
+	var keyFile string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--key-file":
+			if i+1 < len(args) {
+				keyFile = args[i+1]
+				i++
+			} else {
+				return fmt.Errorf("--key-file flag requires a value")
+			}
+		default:
+			return fmt.Errorf("unknown flag: %s", args[i])
+		}
+	}
+
+	// the new vault only exists in memory until Save, so hold the lock from
+	// before it's created
+	unlock, err := cfg.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if keyFile != "" {
+		cfg.Vault.KeyFile = keyFile
+	}
+	if !cfg.HasVault() {
+		utils.InfoPrint("Creating a new vault for connection passwords\n")
+		if err := cfg.InitVault(); err != nil {
+			return fmt.Errorf("failed to create vault: %w", err)
+		}
+	}
+
+	migrated := cfg.PlaintextPasswords()
+	if err := cfg.Save(configFile); err != nil {
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+
+	if len(migrated) == 0 {
+		utils.WarningPrint("No plaintext passwords found, nothing to migrate\n")
+		return nil
+	}
+	utils.SuccessPrint("Moved %d password(s) into the vault\n", len(migrated))
+	return nil
+}
@@ -0,0 +1,41 @@
+package projects
+
+import (
+	"fmt"
+	"os"
+	"proman/config"
+	"strings"
+	"text/tabwriter"
+)
+
+func Where(cfg *config.Config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("where command takes no arguments")
+	}
+
+	fmt.Println("Config files (later files override earlier ones):")
+	for _, file := range cfg.Files() {
+		fmt.Printf("  %s\n", file)
+	}
+	if len(cfg.Files()) > 1 {
+		fmt.Println("(a project-local config can't set binary paths, passwords, SSH tunnels or password commands,")
+		fmt.Println(" nor change the host, port, user or supabase_project_id of a connection from the user config)")
+	}
+	fmt.Println()
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	fmt.Fprintln(w, "---\t-----\t------")
+
+	for _, source := range cfg.Sources() {
+		value := source.Value
+		if strings.HasSuffix(source.Key, ".password") {
+			value = "********"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", source.Key, value, source.File)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Binary struct {
+	Path    string
+	Version string
+}
+
+// binarySearchPatterns are install locations checked in addition to PATH.
+var binarySearchPatterns = []string{
+	"/usr/lib/postgresql/*/bin",
+	"/usr/pgsql-*/bin",
+	"/usr/local/pgsql/bin",
+	"/opt/homebrew/bin",
+	"/opt/homebrew/opt/postgresql@*/bin",
+	"/opt/homebrew/opt/libpq/bin",
+	"/usr/local/bin",
+	"/usr/local/opt/postgresql@*/bin",
+	"/usr/local/opt/libpq/bin",
+	"/home/linuxbrew/.linuxbrew/bin",
+	"/Applications/Postgres.app/Contents/Versions/*/bin",
+	`C:\Program Files\PostgreSQL\*\bin`,
+}
+
+var versionPattern = regexp.MustCompile(`\d+(\.\d+)+|\d+`)
+
+// BinaryVersion runs `path --version` and checks that it really is tool. The
+// PostgreSQL tools print "<tool> (PostgreSQL) <version>", the supabase CLI
+// prints only its version.
+func BinaryVersion(tool, path string) (string, error) {
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return "", fmt.Errorf("%s is not an executable: %w", path, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, resolved, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run %s --version: %w", path, err)
+	}
+
+	output, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	if tool != "supabase" {
+		prefix := tool + " (PostgreSQL) "
+		if !strings.HasPrefix(output, prefix) {
+			return "", fmt.Errorf("%s is not %s (it reported %q)", path, tool, output)
+		}
+		output = strings.TrimPrefix(output, prefix)
+	}
+
+	version := versionPattern.FindString(output)
+	if version == "" {
+		return "", fmt.Errorf("could not read a version from %s --version: %q", path, output)
+	}
+	return version, nil
+}
+
+// DiscoverBinaries finds working installations of tool on PATH and in common
+// install locations, newest version first.
+func DiscoverBinaries(tool string) []Binary {
+	name := tool
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+
+	dirs := filepath.SplitList(os.Getenv("PATH"))
+	for _, pattern := range binarySearchPatterns {
+		matches, _ := filepath.Glob(pattern)
+		dirs = append(dirs, matches...)
+	}
+
+	seen := map[string]bool{}
+	var found []Binary
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		candidate := filepath.Join(dir, name)
+		info, err := os.Stat(candidate)
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		real, err := filepath.EvalSymlinks(candidate)
+		if err != nil {
+			real = candidate
+		}
+		if seen[real] {
+			continue
+		}
+		seen[real] = true
+
+		version, err := BinaryVersion(tool, candidate)
+		if err != nil {
+			continue
+		}
+		found = append(found, Binary{Path: candidate, Version: version})
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return CompareVersions(found[i].Version, found[j].Version) > 0
+	})
+	return found
+}
+
+// CompareVersions compares dotted version numbers numerically.
+func CompareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			fmt.Sscan(as[i], &x)
+		}
+		if i < len(bs) {
+			fmt.Sscan(bs[i], &y)
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -51,3 +51,17 @@ func OpenDiff(file1, file2 string, label1, label2 string, config *config.Config)
 
 	return cmd.Start()
 }
+
+// DiffViewerCommand returns the executable OpenDiff runs for an editor setting.
+func DiffViewerCommand(editor string) string {
+	switch editor {
+	case "zed":
+		return "zed"
+	case "vscode":
+		return "code"
+	case "meld":
+		return "meld"
+	default:
+		return "git"
+	}
+}
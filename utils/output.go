@@ -0,0 +1,369 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Formats accepted by the global --output flag.
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+	OutputCSV   = "csv"
+)
+
+var outputFormat = OutputTable
+
+func SetOutputFormat(format string) error {
+	switch format {
+	case OutputTable, OutputJSON, OutputYAML, OutputCSV:
+		outputFormat = format
+		return nil
+	default:
+		return fmt.Errorf("unknown output format '%s', expected json, yaml, csv or table", format)
+	}
+}
+
+func OutputFormat() string {
+	return outputFormat
+}
+
+// MachineOutput reports whether stdout is reserved for structured output, in
+// which case progress and tool output belong on stderr.
+func MachineOutput() bool {
+	return outputFormat != OutputTable
+}
+
+// CommandOutput is where the output of external tools should go so it doesn't
+// end up mixed into structured output.
+func CommandOutput() io.Writer {
+	if MachineOutput() {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// WriteOutput prints v, a struct or slice of structs, to stdout as JSON, YAML or
+// CSV. Field names come from the json tags. In CSV, nested objects become
+// dotted columns and lists of objects become one row per element.
+func WriteOutput(v any) error {
+	return writeOutput(os.Stdout, outputFormat, v)
+}
+
+func writeOutput(w io.Writer, format string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case OutputJSON:
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, data, "", "  "); err != nil {
+			return err
+		}
+		indented.WriteByte('\n')
+		_, err = indented.WriteTo(w)
+		return err
+	case OutputYAML:
+		node, err := decodeOrdered(data)
+		if err != nil {
+			return err
+		}
+		var out bytes.Buffer
+		writeYAML(&out, node, 0)
+		_, err = out.WriteTo(w)
+		return err
+	case OutputCSV:
+		node, err := decodeOrdered(data)
+		if err != nil {
+			return err
+		}
+		return writeCSV(w, node)
+	default:
+		return fmt.Errorf("output format '%s' has no structured form", format)
+	}
+}
+
+// orderedMap keeps JSON object keys in the order they were encoded in, so the
+// struct field order carries over to YAML and CSV.
+type orderedMap struct {
+	keys   []string
+	values []any
+}
+
+func decodeOrdered(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return decodeValue(dec)
+}
+
+func decodeValue(dec *json.Decoder) (any, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		m := &orderedMap{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			m.keys = append(m.keys, key.(string))
+			m.values = append(m.values, value)
+		}
+		_, err = dec.Token()
+		return m, err
+	case json.Delim('['):
+		list := []any{}
+		for dec.More() {
+			value, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err = dec.Token()
+		return list, err
+	default:
+		return token, nil
+	}
+}
+
+func writeYAML(out *bytes.Buffer, node any, indent int) {
+	pad := strings.Repeat(" ", indent)
+
+	switch n := node.(type) {
+	case *orderedMap:
+		if len(n.keys) == 0 {
+			out.WriteString(pad + "{}\n")
+			return
+		}
+		for i, key := range n.keys {
+			writeYAMLEntry(out, pad+yamlScalar(key)+":", n.values[i], indent)
+		}
+	case []any:
+		if len(n) == 0 {
+			out.WriteString(pad + "[]\n")
+			return
+		}
+		for _, item := range n {
+			if m, ok := item.(*orderedMap); ok && len(m.keys) > 0 {
+				// the first key shares the line with the dash
+				var nested bytes.Buffer
+				writeYAML(&nested, m, indent+2)
+				out.WriteString(pad + "- " + strings.TrimPrefix(nested.String(), pad+"  "))
+				continue
+			}
+			writeYAMLEntry(out, pad+"-", item, indent)
+		}
+	default:
+		out.WriteString(pad + yamlScalar(n) + "\n")
+	}
+}
+
+func writeYAMLEntry(out *bytes.Buffer, prefix string, value any, indent int) {
+	switch v := value.(type) {
+	case *orderedMap:
+		if len(v.keys) == 0 {
+			out.WriteString(prefix + " {}\n")
+			return
+		}
+		out.WriteString(prefix + "\n")
+		writeYAML(out, v, indent+2)
+	case []any:
+		if len(v) == 0 {
+			out.WriteString(prefix + " []\n")
+			return
+		}
+		out.WriteString(prefix + "\n")
+		writeYAML(out, v, indent+2)
+	default:
+		out.WriteString(prefix + " " + yamlScalar(v) + "\n")
+	}
+}
+
+var plainYAMLString = regexp.MustCompile(`^[A-Za-z_/][A-Za-z0-9_./@+-]*$`)
+
+func yamlScalar(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case string:
+		switch strings.ToLower(v) {
+		case "true", "false", "yes", "no", "on", "off", "null", "y", "n":
+			return strconv.Quote(v)
+		}
+		if plainYAMLString.MatchString(v) {
+			return v
+		}
+		return strconv.Quote(v)
+	default:
+		return strconv.Quote(fmt.Sprint(v))
+	}
+}
+
+func writeCSV(w io.Writer, node any) error {
+	records, ok := node.([]any)
+	if !ok {
+		records = []any{node}
+	}
+
+	var columns []string
+	seen := map[string]bool{}
+	var rows []map[string]string
+	for _, record := range records {
+		for _, row := range flattenCSV("", record) {
+			for _, column := range row.columns {
+				if !seen[column] {
+					seen[column] = true
+					columns = append(columns, column)
+				}
+			}
+			rows = append(rows, row.values)
+		}
+	}
+
+	columns = dropEmptyParents(columns, rows)
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		line := make([]string, len(columns))
+		for i, column := range columns {
+			line[i] = row[column]
+		}
+		if err := writer.Write(line); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// dropEmptyParents removes the column a null or empty value left behind when
+// other records flatten the same field into dotted columns, so every record
+// shares one set of columns.
+func dropEmptyParents(columns []string, rows []map[string]string) []string {
+	kept := make([]string, 0, len(columns))
+	for _, column := range columns {
+		hasChildren := false
+		for _, other := range columns {
+			if strings.HasPrefix(other, column+".") {
+				hasChildren = true
+				break
+			}
+		}
+		empty := true
+		for _, row := range rows {
+			if row[column] != "" {
+				empty = false
+				break
+			}
+		}
+		if !hasChildren || !empty {
+			kept = append(kept, column)
+		}
+	}
+	return kept
+}
+
+type csvRow struct {
+	columns []string
+	values  map[string]string
+}
+
+// flattenCSV turns one value into rows. Every list of objects multiplies the
+// rows, repeating the surrounding fields on each.
+func flattenCSV(prefix string, node any) []csvRow {
+	switch n := node.(type) {
+	case *orderedMap:
+		rows := []csvRow{{values: map[string]string{}}}
+		for i, key := range n.keys {
+			column := key
+			if prefix != "" {
+				column = prefix + "." + key
+			}
+			var product []csvRow
+			for _, row := range rows {
+				for _, part := range flattenCSV(column, n.values[i]) {
+					combined := csvRow{
+						columns: append(append([]string{}, row.columns...), part.columns...),
+						values:  make(map[string]string, len(row.values)+len(part.values)),
+					}
+					for k, v := range row.values {
+						combined.values[k] = v
+					}
+					for k, v := range part.values {
+						combined.values[k] = v
+					}
+					product = append(product, combined)
+				}
+			}
+			rows = product
+		}
+		return rows
+	case []any:
+		var nested []csvRow
+		scalars := make([]string, 0, len(n))
+		for _, item := range n {
+			if _, ok := item.(*orderedMap); ok {
+				nested = append(nested, flattenCSV(prefix, item)...)
+			} else {
+				scalars = append(scalars, csvScalar(item))
+			}
+		}
+		if len(nested) > 0 {
+			return nested
+		}
+		return []csvRow{{columns: []string{prefix}, values: map[string]string{prefix: strings.Join(scalars, ",")}}}
+	default:
+		return []csvRow{{columns: []string{prefix}, values: map[string]string{prefix: csvScalar(n)}}}
+	}
+}
+
+func csvScalar(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case json.Number:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// FormatBytes renders a byte count with a binary unit, e.g. 1.5 MiB.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
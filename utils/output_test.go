@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+type outputCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Latency int    `json:"latency_ms"`
+}
+
+type outputTarget struct {
+	ID         string            `json:"id"`
+	Tags       []string          `json:"tags"`
+	Settings   map[string]string `json:"settings"`
+	Endpoint   outputEndpoint    `json:"endpoint"`
+	Pooler     *outputEndpoint   `json:"pooler"`
+	Checks     []outputCheck     `json:"checks"`
+	Extensions []outputCheck     `json:"extensions"`
+	Error      string            `json:"error,omitempty"`
+}
+
+type outputEndpoint struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+var outputTargets = []outputTarget{
+	{
+		ID:       "prod",
+		Tags:     []string{"eu", "critical"},
+		Settings: map[string]string{"sslmode": "verify-full"},
+		Endpoint: outputEndpoint{Host: "db.example.com", Port: 5432},
+		Pooler:   &outputEndpoint{Host: "pooler.example.com", Port: 6543},
+		Checks:   []outputCheck{{"reachable", true, 12}, {"auth", true, 30}},
+		Extensions: []outputCheck{
+			{"pgcrypto", true, 0},
+			{"pg_cron", false, 0},
+		},
+	},
+	{
+		ID:       "dev",
+		Tags:     []string{},
+		Settings: map[string]string{},
+		Endpoint: outputEndpoint{Host: "localhost", Port: 54322},
+		Checks:   []outputCheck{},
+		Error:    "connection refused: is the server running?",
+	},
+}
+
+// outputQuoting holds strings a YAML parser would read as something other
+// than a string, or that can't be written plain.
+var outputQuoting = map[string]any{
+	"keys": []string{
+		"plain", "snake_case", "/var/lib/postgres", "user@host", "v1.2.3",
+		"yes", "No", "ON", "off", "y", "true", "null", "~",
+		"1.0", "15", "0x1F", "2024-03-10",
+		"-leading-dash", ":leading-colon", "trailing:", "a: b", "with space", "#comment", "",
+		"quote\"d", "line\nbreak",
+	},
+}
+
+func TestWriteOutputGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		value  any
+	}{
+		{"targets.yaml", OutputYAML, outputTargets},
+		{"targets.csv", OutputCSV, outputTargets},
+		{"targets.json", OutputJSON, outputTargets},
+		{"single.yaml", OutputYAML, outputTargets[1]},
+		{"single.csv", OutputCSV, outputTargets[1]},
+		{"empty.yaml", OutputYAML, []outputTarget{}},
+		{"quoting.yaml", OutputYAML, outputQuoting},
+		{"quoting.csv", OutputCSV, outputQuoting},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeOutput(&out, tt.format, tt.value); err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, out.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out.Bytes(), want) {
+				t.Errorf("output differs from %s (run with -update to accept):\n%s", golden, out.Bytes())
+			}
+		})
+	}
+}
+
+func TestFlattenCSVMultipliesRows(t *testing.T) {
+	node, err := decodeOrdered([]byte(`{"id": "prod", "a": [{"x": 1}, {"x": 2}], "b": [{"y": "p"}, {"y": "q"}, {"y": "r"}], "c": []}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := flattenCSV("", node)
+	if len(rows) != 6 {
+		t.Fatalf("got %d rows, want 2 x 3", len(rows))
+	}
+	want := [][3]string{{"1", "p", "prod"}, {"1", "q", "prod"}, {"1", "r", "prod"}, {"2", "p", "prod"}, {"2", "q", "prod"}, {"2", "r", "prod"}}
+	for i, row := range rows {
+		got := [3]string{row.values["a.x"], row.values["b.y"], row.values["id"]}
+		if got != want[i] {
+			t.Errorf("row %d = %v, want %v", i, got, want[i])
+		}
+		if value, found := row.values["c"]; !found || value != "" {
+			t.Errorf("row %d: empty list c = %q (found %v), want an empty column", i, value, found)
+		}
+	}
+}
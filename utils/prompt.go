@@ -3,15 +3,35 @@ package utils
 import (
 	"bufio"
 	"fmt"
+	"os"
 
 	"strings"
+
+	"golang.org/x/term"
 )
 
+// Prompt asks on stderr, so prompts don't end up in structured output.
 func Prompt(reader *bufio.Reader, text string) (string, error) {
-	fmt.Print(text)
+	fmt.Fprint(os.Stderr, text)
 	input, err := reader.ReadString('\n')
 	if err != nil {
 		return "", err
 	}
 	return strings.TrimSpace(input), nil
 }
+
+// PromptSecret works like Prompt but doesn't echo the input when stdin is a terminal.
+func PromptSecret(reader *bufio.Reader, text string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return Prompt(reader, text)
+	}
+
+	fmt.Fprint(os.Stderr, text)
+	input, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(input)), nil
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/briandowns/spinner"
@@ -9,6 +10,9 @@ import (
 
 func NewSpinner(format string, a ...any) *spinner.Spinner {
 	spin := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+	if MachineOutput() {
+		spinner.WithWriterFile(os.Stderr)(spin)
+	}
 	spin.Suffix = fmt.Sprintf(" "+format, a...)
 	spin.Color("blue")
 	return spin
@@ -0,0 +1,18 @@
+package utils
+
+import "runtime/debug"
+
+// Version is set for release builds with -ldflags "-X proman/utils.Version=1.2.3".
+var Version = ""
+
+// ProgramVersion returns proman's own version, falling back to the module
+// version for `go install` builds.
+func ProgramVersion() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}